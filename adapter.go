@@ -2,11 +2,17 @@ package dbg
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go/token"
 	"io"
 	"os"
-	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/traefik-contrib/yaegi-debug-adapter/internal/iox"
 	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
@@ -28,6 +34,52 @@ type Options struct {
 
 	// Non-fatal errors will be sent to Errors if it is non-nil
 	Errors chan<- error
+
+	// If Watch is non-zero, the sources of the program are polled at this
+	// interval, and the program is restarted when they change.
+	Watch time.Duration
+
+	// Reload returns the current source of a program compiled from a string,
+	// when it is restarted. If Reload is nil, the initial source is compiled
+	// again.
+	Reload func() (string, error)
+
+	// Symbols are used in order by the interpreter once NewInterpreter
+	// created it. Their origin describes their packages in the modules
+	// request.
+	Symbols []Symbols
+
+	// If ImportUsed is set, the packages of Symbols are imported by the
+	// interpreter, see interp.Interpreter.ImportUsed.
+	ImportUsed bool
+
+	// Formatters format the values of types in variables, in addition to the
+	// formatters of common standard library types, which they override.
+	Formatters []Formatter
+
+	// MaxValueDepth is the maximum nesting depth of the maps, slices, arrays
+	// and structs printed in the value of a variable. If it is zero, the
+	// depth is limited to 5.
+	MaxValueDepth int
+
+	// If CallStringMethods is set, the values of variables implementing error
+	// or fmt.Stringer are shown with their Error or String method, unless a
+	// formatter is registered for their type. The method is called on another
+	// routine, and the value is shown as usual if it panics or does not
+	// return within StringMethodTimeout, 100ms if it is zero. The methods
+	// declared by the main package of the program are called for struct
+	// types with distinct fields, outside of the debugged program: they see
+	// its package variables as declared, not their current values. Panic
+	// values are always shown with their method, within the timeout.
+	CallStringMethods   bool
+	StringMethodTimeout time.Duration
+}
+
+// Symbols are precompiled symbols used by the interpreter, such as
+// stdlib.Symbols, and their origin, such as "stdlib".
+type Symbols struct {
+	Origin  string
+	Exports interp.Exports
 }
 
 type compileFunc func(*interp.Interpreter, string) (*interp.Program, error)
@@ -37,23 +89,44 @@ type Adapter struct {
 	opts    Options
 	compile compileFunc
 	arg     string
+	src     string // source of the program, if compiled from a string
 
 	session *dap.Session
 	ccaps   *dap.InitializeRequestArguments
 
+	// mu serializes requests, debug events and restarts, which replace the
+	// interpreter, the debugger and the registries
+	mu *sync.Mutex
+
 	interp   *interp.Interpreter
 	program  *interp.Program
 	debugger *interp.Debugger
-
-	events *events
-	frames *frames
-	vars   *variables
+	stale    *atomic.Bool // set once debugger is replaced, to ignore its events
+	unwatch  context.CancelFunc
+	allStop  bool // stop all routines when one stops
+
+	events      *events
+	frames      *frames
+	vars        *variables
+	breakpoints *breakpoints
+	exceptions  *exceptions
+	stepTargets *stepInTargets
+	trails      *lineTrails
+	stops       map[string]map[int]int // line stops of each source
+	methods     map[reflect.Type]reflect.Value
+	panicSteps  *panicSteps
+	interrupts  *interrupts
+	loaded      *loadedSources
+	formatters  *formatters
+	blocking    sync.Map // types whose String or Error method timed out
 }
 
 // NewEvalAdapter returns an Adapter that debugs a Go code represented as a
 // string.
 func NewEvalAdapter(src string, opts *Options) *Adapter {
-	return NewAdapter((*interp.Interpreter).Compile, src, opts)
+	a := NewAdapter((*interp.Interpreter).Compile, src, opts)
+	a.src = src
+	return a
 }
 
 // NewEvalPathAdapter returns an Adapter that debugs Go code located at the
@@ -75,15 +148,344 @@ func NewAdapter(eval compileFunc, arg string, opts *Options) *Adapter {
 	}
 
 	a := new(Adapter)
+	a.mu = new(sync.Mutex)
 	a.opts = *opts
 	a.compile = eval
 	a.arg = arg
 	a.events = newEvents()
 	a.frames = newFrames()
 	a.vars = newVariables()
+	a.breakpoints = newBreakpoints()
+	a.exceptions = newExceptions()
+	a.stepTargets = newStepInTargets()
+	a.trails = newLineTrails()
+	a.stops = map[string]map[int]int{}
+	a.panicSteps = newPanicSteps()
+	a.interrupts = newInterrupts()
+	a.loaded = newLoadedSources()
+	a.formatters = defaultFormatters.with(opts.Formatters)
 	return a
 }
 
+// newInterpreter creates an interpreter whose standard streams are bound to
+// the session, and which uses the symbols of the options.
+func (a *Adapter) newInterpreter() (*interp.Interpreter, error) {
+	i, err := a.opts.NewInterpreter(interp.Options{
+		Stdin:  iox.ReaderFunc(a.stdin),
+		Stdout: iox.WriterFunc(a.stdout),
+		Stderr: iox.WriterFunc(a.stderr),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range a.opts.Symbols {
+		if err := i.Use(s.Exports); err != nil {
+			return nil, err
+		}
+	}
+	if a.opts.ImportUsed {
+		i.ImportUsed()
+	}
+	return i, nil
+}
+
+// debug starts debugging the compiled program. The main routine waits for
+// run.
+func (a *Adapter) debug() {
+	stale := new(atomic.Bool)
+	a.stale = stale
+	a.debugger = a.interp.Debug(context.Background(), a.program, func(e *interp.DebugEvent) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		if stale.Load() {
+			return
+		}
+
+		// the terminate event has no frame, so it has no routine
+		if e.Reason() == interp.DebugTerminate {
+			if _, err := a.debugger.Wait(); err != nil {
+				a.uncaughtPanic(err)
+			}
+			err := a.session.Event("terminated", nil)
+			if a.opts.Errors != nil && err != nil {
+				a.opts.Errors <- err
+			}
+			return
+		}
+
+		if e.Reason() == interp.DebugEnterGoRoutine {
+			err := a.session.Event("thread", &dap.ThreadEventBody{
+				Reason:   "started",
+				ThreadId: e.GoRoutine(),
+			})
+			if a.opts.Errors != nil && err != nil {
+				a.opts.Errors <- err
+			}
+			return
+		}
+
+		if e.Reason() == interp.DebugExitGoRoutine {
+			a.exceptions.Release(e.GoRoutine())
+			a.stepTargets.Release(e.GoRoutine())
+			a.trails.Release(e.GoRoutine())
+			a.panicSteps.Release(e.GoRoutine())
+			a.interrupts.Release(e.GoRoutine())
+			err := a.session.Event("thread", &dap.ThreadEventBody{
+				Reason:   "exited",
+				ThreadId: e.GoRoutine(),
+			})
+			if a.opts.Errors != nil && err != nil {
+				a.opts.Errors <- err
+			}
+			return
+		}
+
+		if frames := e.Frames(0, 1); len(frames) > 0 {
+			a.trails.Add(e.GoRoutine(), e.FrameDepth(), frames[0].Position(), e.Reason())
+		}
+
+		if e.Reason() == interp.DebugPause {
+			// interrupted to stop along with another routine
+			if stop, ok := a.interrupts.Take(e.GoRoutine()); ok {
+				if stop {
+					a.events.Retain(e)
+				} else {
+					a.resume(e.GoRoutine())
+				}
+				return
+			}
+		}
+
+		reason := "breakpoint"
+		switch e.Reason() {
+		case interp.DebugBreak:
+			a.panicSteps.Release(e.GoRoutine())
+			var stop bool
+			if reason, stop = a.shouldBreak(e); !stop {
+				if s, ok := a.panicSteps.Get(e.GoRoutine()); ok {
+					a.reachPanic(e, s)
+				} else if stop, ok := a.interrupts.Take(e.GoRoutine()); ok && stop {
+					// resuming would drop the interrupt, stop as if paused
+					a.events.Retain(e)
+				} else {
+					a.resume(e.GoRoutine())
+				}
+				return
+			}
+			a.panicSteps.Release(e.GoRoutine())
+			a.stepTargets.Release(e.GoRoutine())
+		case interp.DebugStepInto, interp.DebugStepOver, interp.DebugStepOut:
+			if s, ok := a.panicSteps.Get(e.GoRoutine()); ok {
+				if !a.reachPanic(e, s) {
+					return
+				}
+				reason = "exception"
+			} else if a.continueStepIn(e) {
+				return
+			}
+		}
+
+		a.purge(e.GoRoutine())
+		a.interrupts.Release(e.GoRoutine())
+
+		a.events.Retain(e)
+
+		body := new(dap.StoppedEventBody)
+		body.ThreadId = dap.Int(e.GoRoutine())
+		switch e.Reason() {
+		case interp.DebugBreak:
+			body.Reason = reason
+		case interp.DebugStepInto, interp.DebugStepOver, interp.DebugStepOut:
+			body.Reason = "step"
+			if reason == "exception" {
+				body.Reason = reason
+			}
+		case interp.DebugEntry:
+			body.Reason = "entry"
+		default:
+			body.Reason = "pause"
+		}
+		if reason != "exception" {
+			// a recovered panic is over once the routine stops elsewhere
+			a.exceptions.ReleaseRecovered(e.GoRoutine())
+		} else if ex, ok := a.exceptions.Get(e.GoRoutine()); ok {
+			body.Description = dap.Str("Paused on panic")
+			if ex.recovered {
+				body.Description = dap.Str("Paused on recovered panic")
+			}
+			body.Text = dap.Str(a.exceptionText(ex))
+		}
+		if a.allStop {
+			a.stopAll(e.GoRoutine())
+			body.AllThreadsStopped = dap.Bool(true)
+		}
+		err := a.session.Event("stopped", body)
+		if a.opts.Errors != nil && err != nil {
+			a.opts.Errors <- err
+		}
+
+		if !a.allStop {
+			// the values shown for the other stopped routines may have changed
+			for _, id := range a.events.IDs() {
+				if id != e.GoRoutine() {
+					a.invalidate(id, "variables")
+				}
+			}
+		}
+	}, &interp.DebugOptions{
+		GoRoutineStartAt1: true,
+	})
+	a.sendLoadedSources()
+}
+
+// restart recompiles the program with a new interpreter and runs it again,
+// with the same breakpoints, and with the launch arguments of the request if
+// they are not nil. The source of a program compiled from a string is
+// reloaded if Reload is set. If the program cannot be compiled, the previous
+// one keeps running. The caller must hold mu.
+func (a *Adapter) restart(args *dap.LaunchRequestArguments) (bool, string) {
+	if a.debugger == nil {
+		return false, "Failed to restart: the program is not launched"
+	}
+
+	i, err := a.newInterpreter()
+	if err != nil {
+		return false, fmt.Sprintf("Failed to create the interpreter: %v", err)
+	}
+
+	arg := a.arg
+	if a.src != "" && a.opts.Reload != nil {
+		if arg, err = a.opts.Reload(); err != nil {
+			return false, fmt.Sprintf("Failed to reload: %v", err)
+		}
+	}
+
+	prog, err := a.compile(i, arg)
+	if err != nil {
+		_ = a.session.Event("output", &dap.OutputEventBody{
+			Category: dap.Str("stderr"),
+			Output:   err.Error(),
+			Data:     err,
+		})
+		return false, fmt.Sprintf("Failed to compile: %v", err)
+	}
+	if a.src != "" {
+		a.src = arg
+	}
+	a.arg = arg
+	if args != nil {
+		a.allStop = args.StopAll.True()
+	}
+
+	// the previous debugger must not end the session once terminated
+	a.stale.Store(true)
+	a.debugger.Terminate()
+
+	a.interp, a.program = i, prog
+	a.loaded.Reset()
+	a.events = newEvents()
+	a.frames = newFrames()
+	a.vars = newVariables()
+	a.exceptions = newExceptions()
+	a.stepTargets = newStepInTargets()
+	a.trails = newLineTrails()
+	a.stops = map[string]map[int]int{}
+	a.methods = nil
+	a.panicSteps = newPanicSteps()
+	a.interrupts = newInterrupts()
+	a.debug()
+	a.restoreBreakpoints()
+	return a.run()
+}
+
+// restartArguments returns the launch arguments of a restart request, or nil
+// if the request does not change them. The arguments of an attach request are
+// a subset of those of a launch request.
+func restartArguments(args *dap.RestartArguments) (*dap.LaunchRequestArguments, error) {
+	if args == nil || args.Arguments == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(args.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	launch := new(dap.LaunchRequestArguments)
+	if err := json.Unmarshal(b, launch); err != nil {
+		return nil, err
+	}
+	return launch, nil
+}
+
+// stopAll interrupts the running routines other than the given one.
+func (a *Adapter) stopAll(id int) {
+	for _, r := range a.debugger.GoRoutines() {
+		if r.ID() == id {
+			continue
+		}
+		if _, stopped := a.events.Get(r.ID()); stopped {
+			continue
+		}
+		if a.debugger.Interrupt(r.ID(), interp.DebugPause) {
+			a.interrupts.Add(r.ID())
+		}
+	}
+}
+
+// continueAll resumes every stopped routine, and the routines that were
+// interrupted but did not pause yet.
+func (a *Adapter) continueAll() (bool, string) {
+	a.interrupts.Cancel()
+	for _, id := range a.events.IDs() {
+		a.release(id)
+		if ok, msg := a.cont(id); !ok {
+			return false, msg
+		}
+	}
+	return true, ""
+}
+
+// release releases the event of a routine that resumes, and the frames and
+// variables of its stop.
+func (a *Adapter) release(id int) {
+	a.events.Release(id)
+	a.purge(id)
+}
+
+// purge removes the frames and variables of the routine, and the variables
+// that belong to no routine.
+func (a *Adapter) purge(id int) {
+	a.frames.Purge(id)
+	a.vars.Purge(id)
+	a.vars.Purge(0)
+}
+
+// invalidate tells the client that the areas of the routine, or of every
+// routine if id is 0, must be fetched again.
+func (a *Adapter) invalidate(id int, areas ...string) {
+	if !a.ccaps.SupportsInvalidatedEvent.True() {
+		return
+	}
+
+	body := &dap.InvalidatedEventBody{Areas: areas}
+	if id != 0 {
+		body.ThreadId = dap.Int(id)
+	}
+	err := a.session.Event("invalidated", body)
+	if a.opts.Errors != nil && err != nil {
+		a.opts.Errors <- err
+	}
+}
+
+// run starts the main routine, halting on entry if StopAtEntry is set.
+func (a *Adapter) run() (bool, string) {
+	if a.opts.StopAtEntry {
+		return a.step(1, interp.DebugEntry)
+	}
+	return a.cont(1)
+}
+
 func (a *Adapter) step(id int, reason interp.DebugEventReason) (bool, string) {
 	err := a.debugger.Step(id, reason)
 	if err == nil {
@@ -100,6 +502,44 @@ func (a *Adapter) cont(id int) (bool, string) {
 	return false, fmt.Sprintf("routine %d: failed to continue: %v", id, err)
 }
 
+// resume continues a routine from within the debug callback.
+func (a *Adapter) resume(id int) {
+	// the debugger may be replaced by a restart meanwhile
+	dbg := a.debugger
+	go func() {
+		if err := dbg.Continue(id); err != nil {
+			a.console("routine %d: failed to continue: %v\n", id, err)
+		}
+	}()
+}
+
+// stepFrom steps a routine from within the debug callback. The step is made
+// under mu once the callback returned, when the routine waits to be resumed.
+func (a *Adapter) stepFrom(id int, reason interp.DebugEventReason) {
+	// the debugger may be replaced by a restart meanwhile
+	dbg := a.debugger
+	go func() {
+		for {
+			a.mu.Lock()
+			if a.debugger != dbg {
+				a.mu.Unlock()
+				return
+			}
+			err := dbg.Step(id, reason)
+			if err != nil && !errors.Is(err, interp.ErrRunning) {
+				a.console("routine %d: failed to step: %v\n", id, err)
+			}
+			a.mu.Unlock()
+
+			if !errors.Is(err, interp.ErrRunning) {
+				return
+			}
+			// the routine waits right after the callback returns
+			runtime.Gosched()
+		}
+	}()
+}
+
 // read from stdin.
 func (a *Adapter) stdin(b []byte) (int, error) {
 	return 0, io.EOF
@@ -129,12 +569,39 @@ func (a *Adapter) stderr(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// send a console output event.
+func (a *Adapter) console(format string, args ...interface{}) {
+	err := a.session.Event("output", &dap.OutputEventBody{
+		Category: dap.Str("console"),
+		Output:   fmt.Sprintf(format, args...),
+	})
+	if a.opts.Errors != nil && err != nil {
+		a.opts.Errors <- err
+	}
+}
+
 // Initialize implements dap.Handler and should not be called directly.
 func (a *Adapter) Initialize(s *dap.Session, ccaps *dap.InitializeRequestArguments) (*dap.Capabilities, error) {
 	a.session, a.ccaps = s, ccaps
 	return &dap.Capabilities{
-		SupportsConfigurationDoneRequest: dap.Bool(true),
-		SupportsFunctionBreakpoints:      dap.Bool(true),
+		SupportsConfigurationDoneRequest:   dap.Bool(true),
+		SupportsFunctionBreakpoints:        dap.Bool(true),
+		SupportsEvaluateForHovers:          dap.Bool(true),
+		SupportsConditionalBreakpoints:     dap.Bool(true),
+		SupportsHitConditionalBreakpoints:  dap.Bool(true),
+		SupportsLogPoints:                  dap.Bool(true),
+		ExceptionBreakpointFilters:         exceptionFilters,
+		SupportsExceptionInfoRequest:       dap.Bool(true),
+		SupportsSetVariable:                dap.Bool(true),
+		SupportsSetExpression:              dap.Bool(true),
+		SupportsCompletionsRequest:         dap.Bool(true),
+		SupportsLoadedSourcesRequest:       dap.Bool(true),
+		SupportsModulesRequest:             dap.Bool(true),
+		SupportsRestartRequest:             dap.Bool(true),
+		SupportsBreakpointLocationsRequest: dap.Bool(true),
+		SupportsStepInTargetsRequest:       dap.Bool(true),
+		SupportsValueFormattingOptions:     dap.Bool(true),
+		CompletionTriggerCharacters:        []string{"."},
 	}, nil
 }
 
@@ -147,6 +614,9 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 		return nil
 	}
 
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	var stop bool
 
 	success := false
@@ -154,11 +624,14 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 	var body dap.ResponseBody
 	switch m.Command {
 	case "launch", "attach":
-		i, err := a.opts.NewInterpreter(interp.Options{
-			Stdin:  iox.ReaderFunc(a.stdin),
-			Stdout: iox.WriterFunc(a.stdout),
-			Stderr: iox.WriterFunc(a.stderr),
-		})
+		switch args := m.Arguments.(type) {
+		case *dap.LaunchRequestArguments:
+			a.allStop = args.StopAll.True()
+		case *dap.AttachRequestArguments:
+			a.allStop = args.StopAll.True()
+		}
+
+		i, err := a.newInterpreter()
 		if err != nil {
 			return err
 		}
@@ -182,151 +655,148 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 			return err
 		}
 
-		a.debugger = a.interp.Debug(context.Background(), a.program, func(e *interp.DebugEvent) {
-			if e.Reason() == interp.DebugEnterGoRoutine {
-				err := a.session.Event("thread", &dap.ThreadEventBody{
-					Reason:   "started",
-					ThreadId: e.GoRoutine(),
-				})
-				if a.opts.Errors != nil && err != nil {
-					a.opts.Errors <- err
-				}
-				return
-			}
-
-			if e.Reason() == interp.DebugExitGoRoutine {
-				err := a.session.Event("thread", &dap.ThreadEventBody{
-					Reason:   "exited",
-					ThreadId: e.GoRoutine(),
-				})
-				if a.opts.Errors != nil && err != nil {
-					a.opts.Errors <- err
-				}
-				return
-			}
-
-			a.frames.Purge()
-			a.vars.Purge()
-
-			if e.Reason() == interp.DebugTerminate {
-				err := a.session.Event("terminated", nil)
-				if a.opts.Errors != nil && err != nil {
-					a.opts.Errors <- err
-				}
-				stop = true
-				return
-			}
-
-			a.events.Retain(e)
-
-			body := new(dap.StoppedEventBody)
-			body.ThreadId = dap.Int(e.GoRoutine())
-			switch e.Reason() {
-			case interp.DebugBreak:
-				body.Reason = "breakpoint"
-			case interp.DebugStepInto, interp.DebugStepOver, interp.DebugStepOut:
-				body.Reason = "step"
-			case interp.DebugEntry:
-				body.Reason = "entry"
-			default:
-				body.Reason = "pause"
-			}
-			err := a.session.Event("stopped", body)
-			if a.opts.Errors != nil && err != nil {
-				a.opts.Errors <- err
-			}
-		}, &interp.DebugOptions{
-			GoRoutineStartAt1: true,
-		})
+		a.debug()
+		if a.opts.Watch > 0 {
+			a.watch()
+		}
 
 	case "setBreakpoints":
 		args := m.Arguments.(*dap.SetBreakpointsArguments)
-		if args.Source.Path == nil {
+		source := a.requestSource(&args.Source)
+		if source == "" {
 			message = "Missing source"
 			break
 		}
 
-		var target interp.BreakpointTarget
-		if path := args.Source.Path.Get(); path == a.opts.SrcPath {
-			target = interp.ProgramBreakpointTarget(a.program)
-		} else {
-			target = interp.PathBreakpointTarget(path)
-		}
-
-		var req []interp.BreakpointRequest
+		var bps []*breakpoint
 		if args.Breakpoints != nil {
-			req = make([]interp.BreakpointRequest, len(args.Breakpoints))
-			for i := range req {
+			bps = make([]*breakpoint, len(args.Breakpoints))
+			for i := range bps {
 				b := args.Breakpoints[i]
 				if a.ccaps.LinesStartAt1.False() {
 					b.Line++
 				}
 
-				req[i] = interp.LineBreakpoint(b.Line)
+				bps[i] = newBreakpoint(interp.LineBreakpoint(b.Line), b.Condition.GetOr(""), b.HitCondition.GetOr(""))
+				bps[i].setLogMessage(b.LogMessage.GetOr(""))
 			}
 		} else {
-			req = make([]interp.BreakpointRequest, len(args.Lines))
-			for i := range req {
+			bps = make([]*breakpoint, len(args.Lines))
+			for i := range bps {
 				l := args.Lines[i]
 				if a.ccaps.LinesStartAt1.False() {
 					l++
 				}
 
-				req[i] = interp.LineBreakpoint(l)
+				bps[i] = newBreakpoint(interp.LineBreakpoint(l), "", "")
 			}
 		}
 
-		res := a.debugger.SetBreakpoints(target, req...)
 		success = true
 		body = &dap.SetBreakpointsResponseBody{
-			Breakpoints: a.convertBreakpoints(res),
+			Breakpoints: a.setBreakpoints(source, bps),
 		}
 
+	case "breakpointLocations":
+		args := m.Arguments.(*dap.BreakpointLocationsArguments)
+		source := a.requestSource(&args.Source)
+		if source == "" {
+			message = "Missing source"
+			break
+		}
+
+		success = true
+		body = &dap.BreakpointLocationsResponseBody{Breakpoints: a.breakpointLocations(source, args)}
+
 	case "setFunctionBreakpoints":
 		args := m.Arguments.(*dap.SetFunctionBreakpointsArguments)
 
-		req := make([]interp.BreakpointRequest, len(args.Breakpoints))
+		bps := make([]*breakpoint, len(args.Breakpoints))
 		for i, bp := range args.Breakpoints {
-			req[i] = interp.FunctionBreakpoint(bp.Name)
+			bps[i] = newBreakpoint(interp.FunctionBreakpoint(bp.Name), bp.Condition.GetOr(""), bp.HitCondition.GetOr(""))
 		}
 
-		res := a.debugger.SetBreakpoints(interp.AllBreakpointTarget(), req...)
 		success = true
 		body = &dap.SetFunctionBreakpointsResponseBody{
-			Breakpoints: a.convertBreakpoints(res),
+			Breakpoints: a.setBreakpoints("", bps),
+		}
+
+	case "setExceptionBreakpoints":
+		args := m.Arguments.(*dap.SetExceptionBreakpointsArguments)
+		a.setExceptionBreakpoints(args.Filters)
+		success = true
+		if len(args.Filters) > 0 {
+			message = exceptionLimits
 		}
 
 	case "configurationDone":
-		if a.opts.StopAtEntry {
-			success, message = a.step(1, interp.DebugEntry)
-		} else {
-			success, message = a.cont(1)
+		success, message = a.run()
+
+	case "restart":
+		args, _ := m.Arguments.(*dap.RestartArguments)
+		launch, err := restartArguments(args)
+		if err != nil {
+			message = fmt.Sprintf("Invalid restart arguments: %v", err)
+			break
 		}
+		success, message = a.restart(launch)
 
 	case "continue":
 		args := m.Arguments.(*dap.ContinueArguments)
-		a.events.Release(args.ThreadId)
+		if a.allStop {
+			success, message = a.continueAll()
+			body = &dap.ContinueResponseBody{AllThreadsContinued: dap.Bool(true)}
+			break
+		}
+		a.release(args.ThreadId)
 		success, message = a.cont(args.ThreadId)
 		body = &dap.ContinueResponseBody{AllThreadsContinued: dap.Bool(false)}
 
 	case "stepIn":
 		args := m.Arguments.(*dap.StepInArguments)
-		a.events.Release(args.ThreadId)
+		if args.TargetId != nil {
+			e, ok := a.events.Get(args.ThreadId)
+			if !ok {
+				message = "Invalid thread ID"
+				break
+			}
+			if !a.setStepInTarget(e, args.TargetId.Get()) {
+				message = "Invalid target ID"
+				break
+			}
+		}
+		a.release(args.ThreadId)
 		success, message = a.step(args.ThreadId, interp.DebugStepInto)
 
+	case "stepInTargets":
+		args := m.Arguments.(*dap.StepInTargetsArguments)
+		f, id, ok := a.frames.Get(args.FrameId)
+		if !ok {
+			message = "Invalid frame ID"
+			break
+		}
+		e, ok := a.events.Get(id)
+		if !ok {
+			message = "Invalid frame ID"
+			break
+		}
+
+		success = true
+		body = &dap.StepInTargetsResponseBody{Targets: a.stepInTargets(e, f)}
+
 	case "next":
 		args := m.Arguments.(*dap.NextArguments)
-		a.events.Release(args.ThreadId)
+		a.release(args.ThreadId)
 		success, message = a.step(args.ThreadId, interp.DebugStepOver)
 
 	case "stepOut":
 		args := m.Arguments.(*dap.StepOutArguments)
-		a.events.Release(args.ThreadId)
+		a.release(args.ThreadId)
 		success, message = a.step(args.ThreadId, interp.DebugStepOut)
 
 	case "pause":
 		args := m.Arguments.(*dap.PauseArguments)
-		a.events.Release(args.ThreadId)
+		a.release(args.ThreadId)
 		success = a.debugger.Interrupt(args.ThreadId, interp.DebugPause)
 
 	case "threads":
@@ -358,41 +828,30 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 			end = args.StartFrame.GetOr(0) + args.Levels.Get()
 		}
 
-		frames := e.Frames(args.StartFrame.GetOr(0), end)
-		b.StackFrames = make([]*dap.StackFrame, len(frames))
-		for i, f := range frames {
-			var src *dap.Source
-			pos := f.Position()
-			if pos != (token.Position{}) {
-				if a.ccaps.LinesStartAt1.False() {
-					pos.Line--
-				}
-				if a.ccaps.ColumnsStartAt1.False() {
-					pos.Column--
-				}
-
-				src = new(dap.Source)
-				if prog := f.Program(); prog != nil && prog == a.program {
-					src.Path = dap.Str(a.opts.SrcPath)
-					src.Name = dap.Str(filepath.Base(a.opts.SrcPath))
-				} else {
-					src.Path = dap.Str(pos.Filename)
-					src.Name = dap.Str(filepath.Base(pos.Filename))
-				}
-			}
+		var format valueFormat
+		if args.Format != nil {
+			format = newValueFormat(&args.Format.ValueFormat)
+		}
+		b.StackFrames = a.stackFrames(e, args.StartFrame.GetOr(0), end, format)
 
-			b.StackFrames[i] = &dap.StackFrame{
-				Id:     a.frames.Add(f),
-				Name:   f.Name(),
-				Line:   pos.Line,
-				Column: pos.Column,
-				Source: src,
-			}
+	case "exceptionInfo":
+		args := m.Arguments.(*dap.ExceptionInfoArguments)
+		e, ok := a.events.Get(args.ThreadId)
+		if !ok {
+			message = "Invalid thread ID"
+			break
+		}
+		ex, ok := a.exceptions.Get(args.ThreadId)
+		if !ok {
+			message = "No exception"
+			break
 		}
+		success = true
+		body = a.exceptionInfo(e, ex)
 
 	case "scopes":
 		args := m.Arguments.(*dap.ScopesArguments)
-		f, ok := a.frames.Get(args.FrameId)
+		f, routine, ok := a.frames.Get(args.FrameId)
 		if !ok {
 			message = "Invalid frame ID"
 			break
@@ -413,13 +872,13 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 			b.Scopes[i] = &dap.Scope{
 				Name:               name,
 				PresentationHint:   dap.Str("Locals"),
-				VariablesReference: a.vars.Add(&frameVars{sc}),
+				VariablesReference: a.vars.Add(routine, &frameVars{sc}, a.frames.Format(args.FrameId)),
 			}
 		}
 
 	case "variables":
 		args := m.Arguments.(*dap.VariablesArguments)
-		scope, ok := a.vars.Get(args.VariablesReference)
+		scope, routine, ok := a.vars.Get(args.VariablesReference)
 		if !ok {
 			message = "Invalid variable reference"
 			break
@@ -427,17 +886,175 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 			success = true
 		}
 
+		format := a.vars.Format(args.VariablesReference)
+		if args.Format != nil {
+			format = newValueFormat(args.Format)
+		}
+
 		body = &dap.VariablesResponseBody{
-			Variables: scope.Variables(a),
+			Variables: scope.Variables(a, routine, newPage(args), format),
+		}
+
+	case "setVariable":
+		args := m.Arguments.(*dap.SetVariableArguments)
+		scope, routine, ok := a.vars.Get(args.VariablesReference)
+		if !ok {
+			message = "Invalid variable reference"
+			break
+		}
+
+		rv, err := scope.Set(a, args.Name, args.Value)
+		if err != nil {
+			message = err.Error()
+			break
+		}
+		success = true
+		a.invalidate(0, "variables")
+
+		format := a.vars.Format(args.VariablesReference)
+		if args.Format != nil {
+			format = newValueFormat(args.Format)
+		}
+
+		v := a.newVar(routine, args.Name, rv, format)
+		body = &dap.SetVariableResponseBody{
+			Value:              v.Value,
+			Type:               v.Type,
+			VariablesReference: dap.Int(v.VariablesReference),
+		}
+
+	case "evaluate":
+		args := m.Arguments.(*dap.EvaluateArguments)
+		var f *interp.DebugFrame
+		var routine int
+		if args.FrameId != nil {
+			f, routine, ok = a.frames.Get(args.FrameId.Get())
+			if !ok {
+				message = "Invalid frame ID"
+				break
+			}
+		}
+
+		rv, err := a.evaluate(f, args.Expression)
+		if err != nil {
+			message = err.Error()
+			break
+		}
+		success = true
+
+		if !rv.IsValid() {
+			body = &dap.EvaluateResponseBody{Result: "nil"}
+			break
+		}
+
+		// the debug console has room for longer values than watches and hovers
+		maxLength := defaultValueLength
+		if args.Context.Eq("repl") || args.Context.Eq("clipboard") {
+			maxLength = replValueLength
+		}
+
+		v := a.newVarWithMaxLength(routine, "", rv, newValueFormat(args.Format), maxLength)
+		body = &dap.EvaluateResponseBody{
+			Result:             v.Value,
+			Type:               v.Type,
+			VariablesReference: v.VariablesReference,
+		}
+
+	case "setExpression":
+		args := m.Arguments.(*dap.SetExpressionArguments)
+		var f *interp.DebugFrame
+		var routine int
+		if args.FrameId != nil {
+			f, routine, ok = a.frames.Get(args.FrameId.Get())
+			if !ok {
+				message = "Invalid frame ID"
+				break
+			}
+		}
+
+		rv, err := a.newEvaluator(f).Assign(args.Expression, args.Value)
+		if err != nil {
+			message = err.Error()
+			break
+		}
+		success = true
+		a.invalidate(0, "variables")
+
+		v := a.newVar(routine, "", rv, newValueFormat(args.Format))
+		body = &dap.SetExpressionResponseBody{
+			Value:              v.Value,
+			Type:               v.Type,
+			VariablesReference: dap.Int(v.VariablesReference),
+		}
+
+	case "loadedSources":
+		srcs := a.compiledSources()
+		a.loaded.Update(srcs)
+
+		success = true
+		sources := make([]*dap.Source, len(srcs))
+		for i, src := range srcs {
+			sources[i] = a.dapSource(src.path)
+		}
+		body = &dap.LoadedSourcesResponseBody{Sources: sources}
+
+	case "modules":
+		args := m.Arguments.(*dap.ModulesArguments)
+		mods := a.modules()
+		total := len(mods)
+
+		start := args.StartModule.GetOr(0)
+		if start > total {
+			start = total
+		}
+		mods = mods[start:]
+		if n := args.ModuleCount.GetOr(0); n > 0 && n < len(mods) {
+			mods = mods[:n]
+		}
+
+		success = true
+		body = &dap.ModulesResponseBody{Modules: mods, TotalModules: dap.Int(total)}
+
+	case "source":
+		args := m.Arguments.(*dap.SourceArguments)
+		ref := args.SourceReference
+		if args.Source != nil && args.Source.SourceReference != nil {
+			ref = args.Source.SourceReference.Get()
+		}
+		if a.src == "" || ref != programSourceReference {
+			message = "Unknown source reference"
+			break
 		}
 
+		// the text that was compiled, so that lines match the stack traces
+		success = true
+		body = &dap.SourceResponseBody{Content: a.src, MimeType: dap.Str("text/x-go")}
+
+	case "completions":
+		args := m.Arguments.(*dap.CompletionsArguments)
+		var f *interp.DebugFrame
+		if args.FrameId != nil {
+			f, _, ok = a.frames.Get(args.FrameId.Get())
+			if !ok {
+				message = "Invalid frame ID"
+				break
+			}
+		}
+
+		success = true
+		body = &dap.CompletionsResponseBody{Targets: a.completions(f, args)}
+
 	case "terminate":
-		a.debugger.Terminate()
+		if a.debugger != nil {
+			a.debugger.Terminate()
+		}
 		success = true
 
 	case "disconnect":
 		// Go does not allow forcibly killing a goroutine
-		a.debugger.Terminate()
+		if a.debugger != nil {
+			a.debugger.Terminate()
+		}
 		stop = true
 		success = true
 
@@ -467,32 +1084,82 @@ func (a *Adapter) Process(pm dap.IProtocolMessage) error {
 
 // Terminate implements dap.Handler and should not be called directly.
 func (a *Adapter) Terminate() {
-	if a.debugger != nil {
-		a.debugger.Terminate()
-		_, _ = a.debugger.Wait()
+	a.mu.Lock()
+	if a.unwatch != nil {
+		a.unwatch()
+	}
+	dbg := a.debugger
+	a.mu.Unlock()
+
+	if dbg != nil {
+		dbg.Terminate()
+		_, _ = dbg.Wait()
 	}
 }
 
-func (a *Adapter) convertBreakpoints(in []interp.Breakpoint) (out []*dap.Breakpoint) {
+func (a *Adapter) convertBreakpoints(in []*breakpoint) (out []*dap.Breakpoint) {
 	out = make([]*dap.Breakpoint, len(in))
 	for i, in := range in {
-		if !in.Valid {
+		if in.message != "" {
+			out[i] = &dap.Breakpoint{Verified: false, Message: dap.Str(in.message)}
+			continue
+		}
+		if !in.valid {
 			out[i] = &dap.Breakpoint{Verified: false}
 			continue
 		}
 
+		pos := in.pos
 		if a.ccaps.LinesStartAt1.False() {
-			in.Position.Line--
+			pos.Line--
 		}
 		if a.ccaps.ColumnsStartAt1.False() {
-			in.Position.Column--
+			pos.Column--
 		}
 
 		out[i] = &dap.Breakpoint{
 			Verified: true,
-			Line:     dap.Int(in.Position.Line),
-			Column:   dap.Int(in.Position.Column),
+			Line:     dap.Int(pos.Line),
+			Column:   dap.Int(pos.Column),
+		}
+	}
+	return out
+}
+
+// stackFrames returns the frames of the event from start to end.
+func (a *Adapter) stackFrames(e *interp.DebugEvent, start, end int, format valueFormat) []*dap.StackFrame {
+	frames := e.Frames(start, end)
+	out := make([]*dap.StackFrame, len(frames))
+	for i, f := range frames {
+		src, pos := a.frameSource(f)
+		out[i] = &dap.StackFrame{
+			Id:     a.frames.Add(e.GoRoutine(), f, format),
+			Name:   f.Name(),
+			Line:   pos.Line,
+			Column: pos.Column,
+			Source: src,
 		}
 	}
 	return out
 }
+
+// frameSource returns the source of the frame and its position, adjusted for
+// the client. The source is nil if the frame has no position.
+func (a *Adapter) frameSource(f *interp.DebugFrame) (*dap.Source, token.Position) {
+	pos := f.Position()
+	if pos == (token.Position{}) {
+		return nil, pos
+	}
+
+	if a.ccaps.LinesStartAt1.False() {
+		pos.Line--
+	}
+	if a.ccaps.ColumnsStartAt1.False() {
+		pos.Column--
+	}
+
+	if prog := f.Program(); prog != nil && prog == a.program && (a.src != "" || a.opts.SrcPath != "") {
+		return a.dapSource(a.programPath()), pos
+	}
+	return a.dapSource(pos.Filename), pos
+}
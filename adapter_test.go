@@ -0,0 +1,222 @@
+package dbg
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+// skipRace skips a test that steps routines when the race detector is
+// enabled: yaegi's Debugger.Step reads whether the routine waits to be
+// resumed without synchronization.
+func skipRace(t *testing.T) {
+	t.Helper()
+	if raceEnabled {
+		t.Skip("stepping races in the interpreter")
+	}
+}
+
+// testClient drives an adapter through a session, as a DAP client does.
+type testClient struct {
+	t      *testing.T
+	enc    *dap.Encoder
+	seq    int
+	msgs   chan dap.IProtocolMessage
+	events []*dap.Event // received and not waited for yet
+	done   chan error
+}
+
+// newTestClient runs a session of the adapter, and initializes it.
+func newTestClient(t *testing.T, a *Adapter) *testClient {
+	t.Helper()
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	c := &testClient{
+		t:    t,
+		enc:  dap.NewEncoder(cw),
+		msgs: make(chan dap.IProtocolMessage, 64),
+		done: make(chan error, 1),
+	}
+
+	s := dap.NewSession(sr, sw, a)
+	go func() {
+		c.done <- s.Run()
+		_ = sw.Close()
+	}()
+	go func() {
+		defer close(c.msgs)
+		dec := dap.NewDecoder(cr)
+		for {
+			m, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			c.msgs <- m
+		}
+	}()
+	t.Cleanup(func() {
+		_ = cw.Close()
+		_ = cr.Close()
+	})
+
+	c.request(&dap.InitializeRequestArguments{AdapterID: "test", LinesStartAt1: dap.Bool(true), ColumnsStartAt1: dap.Bool(true)})
+	return c
+}
+
+// next returns the next message sent by the adapter.
+func (c *testClient) next() dap.IProtocolMessage {
+	c.t.Helper()
+	select {
+	case m, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("session closed")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for the adapter")
+	}
+	return nil
+}
+
+// request sends a request and returns its response, keeping the events
+// received meanwhile.
+func (c *testClient) request(args dap.RequestArguments) *dap.Response {
+	c.t.Helper()
+	c.seq++
+	req := &dap.Request{Arguments: args}
+	req.Seq = c.seq
+	if err := c.enc.Encode(req); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		switch m := c.next().(type) {
+		case *dap.Event:
+			c.events = append(c.events, m)
+		case *dap.Response:
+			if m.RequestSeq == c.seq {
+				return m
+			}
+		}
+	}
+}
+
+// event waits for the named event.
+func (c *testClient) event(name string) *dap.Event {
+	c.t.Helper()
+	for i, e := range c.events {
+		if e.Event == name {
+			c.events = append(c.events[:i:i], c.events[i+1:]...)
+			return e
+		}
+	}
+	for {
+		if e, ok := c.next().(*dap.Event); ok {
+			if e.Event == name {
+				return e
+			}
+			c.events = append(c.events, e)
+		}
+	}
+}
+
+// launch launches the program compiled from a string, with breakpoints in
+// its source, and runs it.
+func (c *testClient) launch(bps ...*dap.SourceBreakpoint) {
+	c.t.Helper()
+	c.start()
+	if len(bps) > 0 {
+		r := c.request(&dap.SetBreakpointsArguments{
+			Source:      dap.Source{SourceReference: dap.Int(programSourceReference)},
+			Breakpoints: bps,
+		})
+		if !r.Success {
+			c.t.Fatalf("setBreakpoints failed: %s", r.Message.GetOr(""))
+		}
+	}
+	c.run()
+}
+
+// start launches the program, and waits for it to be configured.
+func (c *testClient) start() {
+	c.t.Helper()
+	if r := c.request(&dap.LaunchRequestArguments{}); !r.Success {
+		c.t.Fatalf("launch failed: %s", r.Message.GetOr(""))
+	}
+	c.event("initialized")
+}
+
+// run ends the configuration of the program, which runs it.
+func (c *testClient) run() {
+	c.t.Helper()
+	if r := c.request(&dap.ConfigurationDoneArguments{}); !r.Success {
+		c.t.Fatalf("configurationDone failed: %s", r.Message.GetOr(""))
+	}
+}
+
+// stopped waits for the program to stop, and returns the event body.
+func (c *testClient) stopped() *dap.StoppedEventBody {
+	c.t.Helper()
+	return c.event("stopped").Body.(*dap.StoppedEventBody)
+}
+
+// cont continues the routine.
+func (c *testClient) cont(id int) {
+	c.t.Helper()
+	if r := c.request(&dap.ContinueArguments{ThreadId: id}); !r.Success {
+		c.t.Fatalf("continue failed: %s", r.Message.GetOr(""))
+	}
+}
+
+// disconnect ends the session and waits for it to stop.
+func (c *testClient) disconnect() {
+	c.t.Helper()
+	if r := c.request(&dap.DisconnectArguments{}); !r.Success {
+		c.t.Errorf("disconnect failed: %s", r.Message.GetOr(""))
+	}
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for the session to stop")
+	}
+}
+
+func TestAdapter_launchTerminate(t *testing.T) {
+	a := NewEvalAdapter("package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n", nil)
+	c := newTestClient(t, a)
+	c.launch()
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_restart(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+
+	if r := c.request(&dap.RestartArguments{}); r.Success {
+		t.Error("restart succeeded before launch")
+	}
+
+	c.launch(&dap.SourceBreakpoint{Line: 5})
+	if stop := c.stopped(); stop.AllThreadsStopped.True() {
+		t.Error("all threads stopped without stopAll")
+	}
+
+	r := c.request(&dap.RestartArguments{Arguments: map[string]interface{}{"stopAll": true}})
+	if !r.Success {
+		t.Fatalf("restart failed: %s", r.Message.GetOr(""))
+	}
+	stop := c.stopped()
+	if !stop.AllThreadsStopped.True() {
+		t.Error("the restart arguments were not applied")
+	}
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
@@ -0,0 +1,527 @@
+package dbg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+)
+
+// breakpoint is a breakpoint requested by the client.
+type breakpoint struct {
+	request      interp.BreakpointRequest
+	condition    string
+	hitCondition string // expression of the hit count, see hitConditionExpr
+	logMessage   string // logged instead of stopping if set
+	message      string // set if the breakpoint cannot be installed
+
+	// breakpoints on calls to panic and recover, see exceptionSites
+	exception string
+	column    int
+	expr      string
+
+	valid bool
+	pos   token.Position // resolved position, as reported by the interpreter
+	hits  int
+}
+
+func newBreakpoint(req interp.BreakpointRequest, condition, hitCondition string) *breakpoint {
+	bp := &breakpoint{request: req, condition: condition}
+	if condition != "" {
+		if _, err := parser.ParseExpr(condition); err != nil {
+			bp.message = "Invalid condition: " + err.Error()
+			return bp
+		}
+	}
+	if hitCondition != "" {
+		bp.hitCondition = hitConditionExpr(hitCondition)
+		if _, err := parser.ParseExpr(bp.hitCondition); err != nil {
+			bp.message = "Invalid hit condition: " + err.Error()
+		}
+	}
+	return bp
+}
+
+// setLogMessage turns bp into a logpoint. The message may contain
+// expressions enclosed in braces, evaluated each time the logpoint is hit.
+func (bp *breakpoint) setLogMessage(msg string) {
+	if msg == "" || bp.message != "" {
+		return
+	}
+	bp.logMessage = msg
+	_, err := interpolate(msg, func(expr string) string {
+		if _, err := parser.ParseExpr(expr); err != nil {
+			bp.message = "Invalid log message: " + err.Error()
+		}
+		return ""
+	})
+	if err != nil {
+		bp.message = "Invalid log message: " + err.Error()
+	}
+}
+
+// interpolate replaces the {expr} placeholders of msg by eval(expr). Literal
+// braces are written as {{ and }}.
+func interpolate(msg string, eval func(expr string) string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		switch {
+		case (c == '{' || c == '}') && i+1 < len(msg) && msg[i+1] == c:
+			b.WriteByte(c)
+			i++
+		case c == '{':
+			depth, j := 1, i+1
+			for ; j < len(msg) && depth > 0; j++ {
+				switch msg[j] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if depth > 0 {
+				return "", errors.New("unterminated {")
+			}
+			b.WriteString(eval(msg[i+1 : j-1]))
+			i = j - 1
+		case c == '}':
+			return "", errors.New("unexpected }")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// hitConditionExpr returns the expression of the hit count for a hit
+// condition such as "== 5", ">= 10" or "% 100 == 0". A plain number breaks on
+// that hit, and a plain modulo breaks on its multiples.
+func hitConditionExpr(s string) string {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return ""
+	case s[0] >= '0' && s[0] <= '9':
+		return "hits == " + s
+	case s[0] == '%' && !strings.ContainsAny(s, "=<>!"):
+		return "hits " + s + " == 0"
+	default:
+		return "hits " + s
+	}
+}
+
+// matchHitCondition reports whether the hit count satisfies the hit condition
+// expression.
+func matchHitCondition(expr string, hits int) (bool, error) {
+	e := &evaluator{
+		vars: func(name string) (reflect.Value, bool) {
+			return reflect.ValueOf(hits), name == "hits"
+		},
+		pkgs: func(string) (map[string]reflect.Value, bool, error) {
+			return nil, false, nil
+		},
+	}
+	rv, err := e.Eval(expr)
+	if err != nil {
+		return false, err
+	}
+	if rv.Kind() != rBool {
+		return false, errNotBool
+	}
+	return rv.Bool(), nil
+}
+
+// breakpoints holds the breakpoints of each source: the ones requested by the
+// client, and the ones installed by the adapter for exception filters.
+// Function breakpoints are stored with an empty source.
+type breakpoints struct {
+	mu         *sync.Mutex
+	values     map[string][]*breakpoint
+	exceptions map[string][]*breakpoint
+	filters    map[string]bool
+}
+
+func newBreakpoints() *breakpoints {
+	b := new(breakpoints)
+	b.mu = new(sync.Mutex)
+	b.values = map[string][]*breakpoint{}
+	b.exceptions = map[string][]*breakpoint{}
+	b.filters = map[string]bool{}
+	return b
+}
+
+func (r *breakpoints) Set(source string, bps []*breakpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[source] = bps
+}
+
+func (r *breakpoints) SetExceptions(source string, bps []*breakpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(bps) == 0 {
+		delete(r.exceptions, source)
+		return
+	}
+	r.exceptions[source] = bps
+}
+
+// Get returns the client and exception breakpoints of the source.
+func (r *breakpoints) Get(source string) []*breakpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bps := make([]*breakpoint, 0, len(r.values[source])+len(r.exceptions[source]))
+	bps = append(bps, r.values[source]...)
+	return append(bps, r.exceptions[source]...)
+}
+
+// ExceptionSources returns the sources with exception breakpoints.
+func (r *breakpoints) ExceptionSources() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sources := make([]string, 0, len(r.exceptions))
+	for source := range r.exceptions {
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// Sources returns the sources with client breakpoints.
+func (r *breakpoints) Sources() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sources := make([]string, 0, len(r.values))
+	for source := range r.values {
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+func (r *breakpoints) SetFilters(filters []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.filters = map[string]bool{}
+	for _, f := range filters {
+		r.filters[f] = true
+	}
+}
+
+func (r *breakpoints) Filters() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	filters := make([]string, 0, len(r.filters))
+	for f := range r.filters {
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+func (r *breakpoints) Filter(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.filters[name]
+}
+
+// At returns the valid breakpoints resolved to the line of pos.
+func (r *breakpoints) At(pos token.Position) []*breakpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var bps []*breakpoint
+	for _, m := range []map[string][]*breakpoint{r.values, r.exceptions} {
+		for _, values := range m {
+			for _, bp := range values {
+				if bp.valid && bp.pos.Line == pos.Line && bp.pos.Filename == pos.Filename {
+					bps = append(bps, bp)
+				}
+			}
+		}
+	}
+	return bps
+}
+
+// Hit increments and returns the hit count of bp.
+func (r *breakpoints) Hit(bp *breakpoint) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bp.hits++
+	return bp.hits
+}
+
+// ResetHits sets the hit count of every client breakpoint to zero.
+func (r *breakpoints) ResetHits() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, values := range r.values {
+		for _, bp := range values {
+			bp.hits = 0
+		}
+	}
+}
+
+// setBreakpoints replaces the client breakpoints of the source with bps.
+func (a *Adapter) setBreakpoints(source string, bps []*breakpoint) []*dap.Breakpoint {
+	a.breakpoints.Set(source, bps)
+	a.installBreakpoints(source)
+	return a.convertBreakpoints(bps)
+}
+
+// installBreakpoints installs the client and exception breakpoints of the
+// source, skipping the ones that cannot be installed.
+func (a *Adapter) installBreakpoints(source string) {
+	var req []interp.BreakpointRequest
+	var installed []*breakpoint
+	for _, bp := range a.breakpoints.Get(source) {
+		if bp.message == "" {
+			req = append(req, bp.request)
+			installed = append(installed, bp)
+		}
+	}
+
+	res := a.debugger.SetBreakpoints(a.breakpointTarget(source), req...)
+	for i, r := range res {
+		bp := installed[i]
+		bp.valid = r.Valid
+		bp.pos = r.Position
+	}
+}
+
+// restoreBreakpoints installs the breakpoints of a restarted program. The
+// calls to panic and recover are looked up again in the recompiled sources.
+func (a *Adapter) restoreBreakpoints() {
+	a.breakpoints.ResetHits()
+	for _, source := range a.breakpoints.Sources() {
+		a.installBreakpoints(source)
+	}
+	a.setExceptionBreakpoints(a.breakpoints.Filters())
+}
+
+// breakpointLocations returns the positions in the range of the request
+// where the interpreter can stop, see lineStops.
+func (a *Adapter) breakpointLocations(source string, args *dap.BreakpointLocationsArguments) []*dap.BreakpointLocation {
+	line, endLine := args.Line, args.EndLine.GetOr(args.Line)
+	column, endColumn := args.Column.GetOr(0), args.EndColumn.GetOr(0)
+	if a.ccaps.LinesStartAt1.False() {
+		line++
+		endLine++
+	}
+	if a.ccaps.ColumnsStartAt1.False() && column > 0 {
+		column++
+	}
+	if a.ccaps.ColumnsStartAt1.False() && endColumn > 0 {
+		endColumn++
+	}
+
+	out := []*dap.BreakpointLocation{}
+	stops := a.lineStops(source)
+	for l := line; l <= endLine; l++ {
+		col, ok := stops[l]
+		switch {
+		case !ok:
+			continue
+		case l == line && column > 0 && col < column:
+			continue
+		case l == endLine && endColumn > 0 && col > endColumn:
+			continue
+		}
+
+		pos := token.Position{Line: l, Column: col}
+		if a.ccaps.LinesStartAt1.False() {
+			pos.Line--
+		}
+		if a.ccaps.ColumnsStartAt1.False() {
+			pos.Column--
+		}
+		out = append(out, &dap.BreakpointLocation{Line: pos.Line, Column: dap.Int(pos.Column)})
+	}
+	return out
+}
+
+// lineStops returns the column where a line breakpoint stops on each line of
+// the source, as resolved by the interpreter. The program is compiled again by
+// another interpreter, which is never run, and a breakpoint is set on each
+// line of the source, so that the installed breakpoints are left untouched.
+// The stops are kept until the program restarts.
+func (a *Adapter) lineStops(source string) map[int]int {
+	if stops, ok := a.stops[source]; ok {
+		return stops
+	}
+	stops := map[int]int{}
+	a.stops[source] = stops
+
+	text := a.sourceText(source)
+	if text == nil {
+		return stops
+	}
+	i, err := a.newInterpreter()
+	if err != nil {
+		return stops
+	}
+	prog, err := a.compile(i, a.arg)
+	if err != nil {
+		return stops
+	}
+
+	// once terminated, the program ends at its first statement
+	dbg := i.Debug(context.Background(), prog, func(*interp.DebugEvent) {}, nil)
+	defer dbg.Terminate()
+
+	target := interp.PathBreakpointTarget(source)
+	if source == a.programPath() {
+		target = interp.ProgramBreakpointTarget(prog)
+	}
+	req := make([]interp.BreakpointRequest, bytes.Count(text, []byte("\n"))+1)
+	for l := range req {
+		req[l] = interp.LineBreakpoint(l + 1)
+	}
+	for _, bp := range dbg.SetBreakpoints(target, req...) {
+		if bp.Valid {
+			stops[bp.Position.Line] = bp.Position.Column
+		}
+	}
+	return stops
+}
+
+// breakpointTarget returns the target of the breakpoints of the source.
+func (a *Adapter) breakpointTarget(source string) interp.BreakpointTarget {
+	switch source {
+	case "":
+		return interp.AllBreakpointTarget()
+	case a.programPath():
+		return interp.ProgramBreakpointTarget(a.program)
+	default:
+		return interp.PathBreakpointTarget(source)
+	}
+}
+
+// shouldBreak reports whether the program should stop for a breakpoint event,
+// and why. It stops if the condition of any client breakpoint at the current
+// position is met, and its hit count then satisfies the hit condition.
+// Logpoints are logged and never stop. Breakpoints whose conditions cannot be
+// evaluated always stop, and the error is reported to the client. Calls to
+// panic and recover stop if their exception filter is enabled.
+func (a *Adapter) shouldBreak(e *interp.DebugEvent) (reason string, stop bool) {
+	frames := e.Frames(0, 1)
+	if len(frames) == 0 {
+		return "breakpoint", true
+	}
+	f := frames[0]
+
+	bps := a.breakpoints.At(f.Position())
+	if len(bps) == 0 {
+		return "breakpoint", true
+	}
+
+	for _, bp := range bps {
+		if bp.exception != "" {
+			if a.exceptionHit(e, f, bp) {
+				return "exception", true
+			}
+			continue
+		}
+
+		if bp.condition != "" {
+			ok, err := a.condition(f, bp.condition)
+			if err != nil {
+				a.console("Failed to evaluate breakpoint condition %q: %v\n", bp.condition, err)
+				return "breakpoint", true
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		hits := a.breakpoints.Hit(bp)
+		if bp.hitCondition != "" {
+			ok, err := matchHitCondition(bp.hitCondition, hits)
+			if err != nil {
+				a.console("Failed to evaluate breakpoint hit condition %q: %v\n", bp.hitCondition, err)
+				return "breakpoint", true
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if bp.logMessage != "" {
+			a.logpoint(f, bp)
+			continue
+		}
+
+		// keep going so that every breakpoint at this position counts the hit
+		reason, stop = "breakpoint", true
+	}
+	return reason, stop
+}
+
+// condition evaluates the condition of a breakpoint. It runs on the routine of
+// the program, so that a panic of the evaluation is recovered rather than
+// ending the program.
+func (a *Adapter) condition(f *interp.DebugFrame, cond string) (ok bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			ok, err = false, fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	rv, err := a.evaluate(f, cond)
+	if err != nil {
+		return false, err
+	}
+	if rv.Kind() != rBool {
+		return false, errNotBool
+	}
+	return rv.Bool(), nil
+}
+
+// logpoint sends the interpolated message of the logpoint to the console.
+func (a *Adapter) logpoint(f *interp.DebugFrame, bp *breakpoint) {
+	vp := a.newValuePrinter(defaultValueLength)
+	msg, _ := interpolate(bp.logMessage, func(expr string) string {
+		rv, err := a.evaluate(f, expr)
+		if err == nil && rv.Kind() == rInterface {
+			// print the dynamic value, nil if there is none
+			rv = rv.Elem()
+		}
+		switch {
+		case err != nil:
+			return "<" + err.Error() + ">"
+		case !rv.IsValid():
+			return "nil"
+		case rv.Kind() == rString:
+			return rv.String()
+		}
+		return vp.printString(rv)
+	})
+
+	src, pos := a.frameSource(f)
+	body := &dap.OutputEventBody{
+		Category: dap.Str("console"),
+		Output:   msg + "\n",
+		Source:   src,
+	}
+	if src != nil {
+		body.Line = dap.Int(pos.Line)
+		body.Column = dap.Int(pos.Column)
+	}
+
+	err := a.session.Event("output", body)
+	if a.opts.Errors != nil && err != nil {
+		a.opts.Errors <- err
+	}
+}
@@ -0,0 +1,300 @@
+package dbg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+func Test_matchHitCondition(t *testing.T) {
+	cases := []struct {
+		cond string
+		hits int
+		out  bool
+	}{
+		{"== 5", 5, true},
+		{"== 5", 6, false},
+		{"5", 5, true},
+		{"5", 4, false},
+		{">= 10", 9, false},
+		{">= 10", 12, true},
+		{"> 2", 3, true},
+		{"% 100 == 0", 200, true},
+		{"% 100 == 0", 150, false},
+		{"%3", 6, true},
+		{"%3", 7, false},
+	}
+	for _, each := range cases {
+		t.Run(each.cond, func(t *testing.T) {
+			got, err := matchHitCondition(hitConditionExpr(each.cond), each.hits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := each.out; got != want {
+				t.Errorf("hits %d: got [%[2]v:%[2]T] want [%[3]v:%[3]T]", each.hits, got, want)
+			}
+		})
+	}
+}
+
+func Test_interpolate(t *testing.T) {
+	eval := func(expr string) string { return "<" + expr + ">" }
+	cases := []struct {
+		msg string
+		out string
+	}{
+		{"plain", "plain"},
+		{"i={i}", "i=<i>"},
+		{"{a} and {b.c}", "<a> and <b.c>"},
+		{"{{literal}}", "{literal}"},
+		{"{m[T{1}]}", "<m[T{1}]>"},
+	}
+	for _, each := range cases {
+		t.Run(each.msg, func(t *testing.T) {
+			got, err := interpolate(each.msg, eval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+
+	for _, msg := range []string{"{open", "close}"} {
+		if _, err := interpolate(msg, eval); err == nil {
+			t.Errorf("expected an error for %q", msg)
+		}
+	}
+}
+
+func Test_newBreakpoint_invalid(t *testing.T) {
+	cases := []struct {
+		name, cond, hitCond string
+	}{
+		{"condition", "i ==", ""},
+		{"hit condition", "", "=="},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			if bp := newBreakpoint(nil, each.cond, each.hitCond); bp.message == "" {
+				t.Error("expected the breakpoint to be rejected")
+			}
+		})
+	}
+}
+
+func TestAdapter_conditionPanic(t *testing.T) {
+	src := `package main
+
+func main() {
+	var x, y interface{} = []int{1}, []int{1}
+	_, _ = x, y
+	println()
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.launch(&dap.SourceBreakpoint{Line: 6, Condition: dap.Str("x == y")})
+
+	// the condition fails to evaluate, so the program stops
+	stop := c.stopped()
+	out := c.event("output").Body.(*dap.OutputEventBody)
+	if !strings.Contains(out.Output, "uncomparable") {
+		t.Errorf("unexpected output %q", out.Output)
+	}
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_breakpointLocations_stops(t *testing.T) {
+	src := `package main
+
+type T struct{ A int }
+
+func (t *T) M() int {
+	return t.A
+}
+
+var global = 3
+
+var z int
+
+const c = 1
+
+func f(x int) (int, error) {
+	var y int
+	y = x + 1
+	if y > 2 {
+		return y, nil
+	} else if y < 0 {
+		panic("neg")
+	}
+	for i := 0; i < 3; i++ {
+		y += i
+		continue
+	}
+	for _, v := range []int{1, 2} {
+		y += v
+	}
+	switch y {
+	case 1:
+		y++
+	default:
+	}
+	ok := y > 0
+	if ok {
+		println("ok")
+	}
+	defer println("done")
+	t := T{A: 1}
+	t.A++
+	s := []int{
+		1,
+		2,
+	}
+	_ = s
+	var err error
+	if err != nil { panic(err) }
+	return y, nil
+}
+
+func main() {
+	const d = 2
+	ch := make(chan int, 1)
+	for {
+		break
+	}
+L:
+	for j := 0; j < 1; j++ {
+		break L
+	}
+	select {
+	case ch <- 1:
+	default:
+	}
+	switch x := 1; x {
+	}
+	var i interface{} = 1
+	switch v := i.(type) {
+	case int:
+		_ = v
+	}
+	g := func() {
+		return
+	}
+	g()
+	if v, ok := i.(int); ok {
+		_ = v
+	}
+	for n := 0; ; n++ {
+		if n > 1 {
+			break
+		}
+	}
+	goto E
+E:
+	println(d, c, z, global)
+	f(1)
+	_ = (&T{}).M()
+}
+
+func unused() {
+	y := 1
+	go func() {
+		_ = y
+	}()
+	println()
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.start()
+	source := dap.Source{SourceReference: dap.Int(programSourceReference)}
+	lines := strings.Count(src, "\n")
+	r := c.request(&dap.BreakpointLocationsArguments{Source: source, Line: 1, EndLine: dap.Int(lines)})
+	got := map[int]int{}
+	for _, l := range r.Body.(*dap.BreakpointLocationsResponseBody).Breakpoints {
+		got[l.Line] = l.Column.Get()
+	}
+
+	// the locations are where the breakpoints of each line are verified
+	bps := make([]*dap.SourceBreakpoint, lines)
+	for l := range bps {
+		bps[l] = &dap.SourceBreakpoint{Line: l + 1}
+	}
+	r = c.request(&dap.SetBreakpointsArguments{Source: source, Breakpoints: bps})
+	want := map[int]int{}
+	for _, bp := range r.Body.(*dap.SetBreakpointsResponseBody).Breakpoints {
+		if bp.Verified {
+			want[bp.Line.Get()] = bp.Column.Get()
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	for _, line := range []int{5, 80} {
+		if _, ok := got[line]; !ok {
+			t.Errorf("no location on line %d", line)
+		}
+	}
+
+	// the breakpoints are replaced by one on a line that is not run
+	c.request(&dap.SetBreakpointsArguments{Source: source, Breakpoints: []*dap.SourceBreakpoint{{Line: lines - 1}}})
+	c.run()
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_breakpointLocations(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.start()
+	c.request(&dap.SetBreakpointsArguments{
+		Source:      dap.Source{SourceReference: dap.Int(programSourceReference)},
+		Breakpoints: []*dap.SourceBreakpoint{{Line: 5}},
+	})
+
+	r := c.request(&dap.BreakpointLocationsArguments{
+		Source:  dap.Source{SourceReference: dap.Int(programSourceReference)},
+		Line:    3,
+		EndLine: dap.Int(6),
+	})
+	var got []string
+	for _, l := range r.Body.(*dap.BreakpointLocationsResponseBody).Breakpoints {
+		got = append(got, fmt.Sprintf("%d:%d", l.Line, l.Column.Get()))
+	}
+	if want := []string{"4:2", "5:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	// the installed breakpoint is left untouched
+	c.run()
+	stop := c.stopped()
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_logpoint(t *testing.T) {
+	src := `package main
+
+func main() {
+	var x any = 42
+	var s any = "text"
+	var n any
+	_, _, _ = x, s, n
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.launch(&dap.SourceBreakpoint{Line: 7, LogMessage: dap.Str("x={x} s={s} n={n}")})
+
+	out := c.event("output").Body.(*dap.OutputEventBody)
+	if got, want := out.Output, "x=42 s=text n=nil\n"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	c.event("terminated")
+	c.disconnect()
+}
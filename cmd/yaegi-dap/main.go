@@ -12,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	dbg "github.com/traefik-contrib/yaegi-debug-adapter"
 	"github.com/traefik-contrib/yaegi-debug-adapter/internal/iox"
@@ -34,6 +35,8 @@ func main() {
 		asString      bool
 		tags          string
 		noAutoImport  bool
+		watch         time.Duration
+		stringMethods bool
 	)
 
 	// The following flags are initialized from environment.
@@ -52,6 +55,8 @@ func main() {
 	flag.BoolVar(&useUnrestricted, "unrestricted", useUnrestricted, "include unrestricted symbols")
 	flag.BoolVar(&useUnsafe, "unsafe", useUnsafe, "include unsafe symbols")
 	flag.BoolVar(&noAutoImport, "noautoimport", false, "do not auto import pre-compiled packages. Import names that would result in collisions (e.g. rand from crypto/rand and rand from math/rand) are automatically renamed (crypto_rand and math_rand)")
+	flag.DurationVar(&watch, "watch", 0, "Poll the sources at the given interval, and restart the program when they change")
+	flag.BoolVar(&stringMethods, "string-methods", false, "Show values with their String or Error method, for types of pre-compiled packages")
 	flag.Usage = func() {
 		fmt.Println("Usage: yaegi debug [options] <path> [args]")
 		fmt.Println("Options:")
@@ -65,47 +70,41 @@ func main() {
 		log.Fatal("missing script path")
 	}
 
-	shouldAutoImport := !noAutoImport
+	symbols := []dbg.Symbols{
+		{Origin: "stdlib", Exports: stdlib.Symbols},
+		{Origin: "interp", Exports: interp.Symbols},
+	}
+	if useSyscall {
+		symbols = append(symbols, dbg.Symbols{Origin: "syscall", Exports: syscall.Symbols})
+	}
+	if useUnsafe {
+		symbols = append(symbols, dbg.Symbols{Origin: "unsafe", Exports: unsafe.Symbols})
+	}
+	if useUnrestricted {
+		// Use of unrestricted symbols should always follow stdlib and syscall symbols, to update them.
+		symbols = append(symbols, dbg.Symbols{Origin: "unrestricted", Exports: unrestricted.Symbols})
+	}
+
 	newInterp := func(opts interp.Options) (*interp.Interpreter, error) {
 		opts.GoPath = build.Default.GOPATH
 		opts.BuildTags = strings.Split(tags, ",")
 		i := interp.New(opts)
-		if err := i.Use(stdlib.Symbols); err != nil {
-			return nil, err
-		}
-		if err := i.Use(interp.Symbols); err != nil {
-			return nil, err
-		}
 		if useSyscall {
-			if err := i.Use(syscall.Symbols); err != nil {
-				return nil, err
-			}
 			// Using a environment var allows a nested interpreter to import the syscall package.
 			if err := os.Setenv("YAEGI_SYSCALL", "1"); err != nil {
 				return nil, err
 			}
 		}
 		if useUnsafe {
-			if err := i.Use(unsafe.Symbols); err != nil {
-				return nil, err
-			}
 			if err := os.Setenv("YAEGI_UNSAFE", "1"); err != nil {
 				return nil, err
 			}
 		}
 		if useUnrestricted {
-			// Use of unrestricted symbols should always follow stdlib and syscall symbols, to update them.
-			if err := i.Use(unrestricted.Symbols); err != nil {
-				return nil, err
-			}
 			if err := os.Setenv("YAEGI_UNRESTRICTED", "1"); err != nil {
 				return nil, err
 			}
 		}
-		if shouldAutoImport {
-			i.ImportUsed()
-		}
-
 		return i, nil
 	}
 
@@ -122,6 +121,11 @@ func main() {
 		NewInterpreter: newInterp,
 		Errors:         errch,
 		SrcPath:        args[0],
+		Symbols:        symbols,
+		ImportUsed:     !noAutoImport,
+		Watch:          watch,
+
+		CallStringMethods: stringMethods,
 	}
 
 	var adp *dbg.Adapter
@@ -132,11 +136,22 @@ func main() {
 			log.Fatal(err)
 		}
 
+		opts.Reload = func() (string, error) {
+			b, err := os.ReadFile(args[0])
+			return string(b), err
+		}
 		adp = dbg.NewEvalAdapter(string(b), opts)
 	} else if src, ok := isScript(args[0]); ok {
+		opts.Reload = func() (string, error) {
+			src, ok := isScript(args[0])
+			if !ok {
+				return "", fmt.Errorf("%s is no longer a script", args[0])
+			}
+			return src, nil
+		}
 		adp = dbg.NewEvalAdapter(src, opts)
 	} else {
-		shouldAutoImport = false
+		opts.ImportUsed = false
 		adp = dbg.NewEvalPathAdapter(args[0], opts)
 	}
 
@@ -0,0 +1,257 @@
+package dbg
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+)
+
+// completions returns the completions of the identifier at the given line
+// and column of the text. After a selector, they are the fields and methods
+// of the operand, or the exported symbols of a package. Otherwise they are
+// the variables of the frame and the package names.
+func (a *Adapter) completions(f *interp.DebugFrame, args *dap.CompletionsArguments) []*dap.CompletionItem {
+	lines := strings.Split(args.Text, "\n")
+	line, column := args.Line.GetOr(1), args.Column
+	if a.ccaps.LinesStartAt1.False() {
+		line++
+	}
+	if a.ccaps.ColumnsStartAt1.False() {
+		column++
+	}
+	if line < 1 || line > len(lines) {
+		return nil
+	}
+	text := lines[line-1]
+	text = text[:byteOffset(text, column-1)]
+
+	operand, prefix := completionTarget(text)
+	var items []*dap.CompletionItem
+	if operand == "" {
+		items = a.scopeCompletions(f)
+	} else {
+		items = a.selectorCompletions(f, operand)
+	}
+
+	start := utf16Len(text[:len(text)-len(prefix)])
+	if !a.ccaps.ColumnsStartAt1.False() {
+		start++
+	}
+
+	var out []*dap.CompletionItem
+	for _, item := range items {
+		if strings.HasPrefix(item.Label, prefix) {
+			item.Start = dap.Int(start)
+			item.Length = dap.Int(utf16Len(prefix))
+			out = append(out, item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Label < out[j].Label })
+	return out
+}
+
+// scopeCompletions returns the variables of the frame and the names of the
+// packages imported by its file.
+func (a *Adapter) scopeCompletions(f *interp.DebugFrame) []*dap.CompletionItem {
+	var items []*dap.CompletionItem
+	seen := map[string]bool{}
+	add := func(label, typ string) {
+		if label == "" || seen[label] {
+			return
+		}
+		seen[label] = true
+		items = append(items, &dap.CompletionItem{Label: label, Type: dap.Str(typ)})
+	}
+
+	if f != nil {
+		for _, sc := range f.Scopes() {
+			for _, v := range sc.Variables() {
+				add(v.Name, "variable")
+			}
+		}
+	}
+	for _, imports := range a.frameImports(f) {
+		for name := range imports {
+			add(name, "module")
+		}
+	}
+	return items
+}
+
+// selectorCompletions returns the exported symbols of the package named
+// operand, or the fields and methods of the value of operand.
+func (a *Adapter) selectorCompletions(f *interp.DebugFrame, operand string) []*dap.CompletionItem {
+	e := a.newEvaluator(f)
+	if _, isVar := e.vars(operand); !isVar {
+		if syms, ok, _ := e.pkgs(operand); ok {
+			var items []*dap.CompletionItem
+			for name, v := range syms {
+				if r, _ := utf8.DecodeRuneInString(name); unicode.IsUpper(r) {
+					items = append(items, &dap.CompletionItem{Label: name, Type: dap.Str(symbolType(v))})
+				}
+			}
+			return items
+		}
+	}
+
+	v, err := e.Eval(operand)
+	if err != nil {
+		return nil
+	}
+	return members(v)
+}
+
+// symbolType returns the completion item type of a package symbol. Variables
+// are exported as the addressable element of a pointer to them, and types as
+// nil pointers.
+func symbolType(v reflect.Value) string {
+	switch {
+	case v.CanAddr():
+		return "variable"
+	case v.Kind() == rFunc:
+		return "function"
+	case v.Kind() == rPtr && v.IsNil():
+		return "class"
+	default:
+		return "value"
+	}
+}
+
+// members returns the fields and methods of v, following pointers and
+// interfaces.
+func members(v reflect.Value) []*dap.CompletionItem {
+	var items []*dap.CompletionItem
+	seen := map[string]bool{}
+	add := func(label, typ string) {
+		if label == "" || seen[label] {
+			return
+		}
+		seen[label] = true
+		items = append(items, &dap.CompletionItem{Label: label, Type: dap.Str(typ)})
+	}
+	addMethods := func(t reflect.Type) {
+		for i := 0; i < t.NumMethod(); i++ {
+			add(t.Method(i).Name, "method")
+		}
+	}
+
+	for v.IsValid() {
+		addMethods(v.Type())
+		if k := v.Kind(); k == rPtr || k == rInterface {
+			if v.IsNil() {
+				break
+			}
+			v = v.Elem()
+			continue
+		}
+
+		if v.CanAddr() {
+			addMethods(reflect.PointerTo(v.Type()))
+		}
+		if v.Kind() == rStruct {
+			t := v.Type()
+			for i := 0; i < t.NumField(); i++ {
+				add(t.Field(i).Name, "field")
+			}
+		}
+		break
+	}
+	return items
+}
+
+// completionTarget splits the text before the cursor into the operand of a
+// selector, if any, and the partial identifier being completed.
+func completionTarget(text string) (operand, prefix string) {
+	i := len(text)
+	for i > 0 {
+		r, n := utf8.DecodeLastRuneInString(text[:i])
+		if !isIdentRune(r) {
+			break
+		}
+		i -= n
+	}
+	prefix = text[i:]
+	if i == 0 || text[i-1] != '.' {
+		return "", prefix
+	}
+
+	end := i - 1
+	return text[operandStart(text[:end]):end], prefix
+}
+
+// operandStart returns the start of the operand ending s, made of
+// identifiers, selectors, and balanced index and call expressions.
+func operandStart(s string) int {
+	i := len(s)
+	for i > 0 {
+		r, n := utf8.DecodeLastRuneInString(s[:i])
+		switch {
+		case isIdentRune(r) || r == '.':
+			i -= n
+		case r == ')' || r == ']':
+			open := matchingOpen(s[:i])
+			if open < 0 {
+				return i
+			}
+			i = open
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// matchingOpen returns the index of the bracket matching the closing bracket
+// ending s, or -1.
+func matchingOpen(s string) int {
+	depth := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		switch s[i] {
+		case ')', ']':
+			depth++
+		case '(', '[':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// byteOffset returns the byte offset of the UTF-16 offset in s.
+func byteOffset(s string, offset int) int {
+	n := 0
+	for i, r := range s {
+		if n >= offset {
+			return i
+		}
+		n += runeLen16(r)
+	}
+	return len(s)
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeLen16(r)
+	}
+	return n
+}
+
+// runeLen16 returns the number of UTF-16 code units encoding r.
+func runeLen16(r rune) int {
+	if r >= 0x10000 && r <= unicode.MaxRune {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,118 @@
+package dbg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+func Test_completionTarget(t *testing.T) {
+	cases := []struct {
+		text    string
+		operand string
+		prefix  string
+	}{
+		{"", "", ""},
+		{"fo", "", "fo"},
+		{"x + fo", "", "fo"},
+		{"p.", "p", ""},
+		{"p.Ta", "p", "Ta"},
+		{"a.b.c", "a.b", "c"},
+		{"m[\"a\"].X", "m[\"a\"]", "X"},
+		{"len(s) + (*p).Y", "(*p)", "Y"},
+		{"été.ça", "été", "ça"},
+	}
+	for _, each := range cases {
+		t.Run(each.text, func(t *testing.T) {
+			operand, prefix := completionTarget(each.text)
+			if got, want := operand, each.operand; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+			if got, want := prefix, each.prefix; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func Test_members(t *testing.T) {
+	p := &evalPoint{}
+	var labels []string
+	for _, item := range members(reflect.ValueOf(p)) {
+		labels = append(labels, item.Label+":"+item.Type.GetOr(""))
+	}
+	if got, want := strings.Join(labels, " "), "X:field Y:field Tags:field"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	var found bool
+	for _, item := range members(reflect.ValueOf(time.Second)) {
+		found = found || item.Label == "Seconds"
+	}
+	if !found {
+		t.Error("missing method Seconds of time.Duration")
+	}
+}
+
+func Test_symbolType(t *testing.T) {
+	symbols := stdlib.Symbols["os/os"]
+	cases := []struct {
+		name string
+		out  string
+	}{
+		{"Args", "variable"},
+		{"Stdin", "variable"},
+		{"Exit", "function"},
+		{"File", "class"},
+		{"O_RDONLY", "value"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			if got, want := symbolType(symbols[each.name]), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func TestAdapter_completions_packages(t *testing.T) {
+	src := `package main
+
+import crand "crypto/rand"
+
+func main() {
+	_ = crand.Int
+	println()
+}
+`
+	newInterpreter := func(opts interp.Options) (*interp.Interpreter, error) {
+		i := interp.New(opts)
+		return i, i.Use(stdlib.Symbols)
+	}
+	c := newTestClient(t, NewEvalAdapter(src, &Options{NewInterpreter: newInterpreter}))
+	c.launch(&dap.SourceBreakpoint{Line: 7})
+	stop := c.stopped()
+	trace := c.request(&dap.StackTraceArguments{ThreadId: stop.ThreadId.Get()}).Body.(*dap.StackTraceResponseBody)
+
+	// the packages are the ones imported by the file of the frame
+	r := c.request(&dap.CompletionsArguments{Text: "cr", Column: 3, FrameId: dap.Int(trace.StackFrames[0].Id)})
+	if !r.Success {
+		t.Fatalf("completions failed: %s", r.Message.GetOr(""))
+	}
+	var got []string
+	for _, item := range r.Body.(*dap.CompletionsResponseBody).Targets {
+		got = append(got, item.Label)
+	}
+	if want := []string{"crand"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
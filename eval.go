@@ -0,0 +1,1028 @@
+package dbg
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+var errNotBool = errors.New("expression is not a boolean")
+
+// basicTypes are the predeclared types that can be used in conversions.
+var basicTypes = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
+	"int":        reflect.TypeOf(int(0)),
+	"int8":       reflect.TypeOf(int8(0)),
+	"int16":      reflect.TypeOf(int16(0)),
+	"int32":      reflect.TypeOf(int32(0)),
+	"int64":      reflect.TypeOf(int64(0)),
+	"uint":       reflect.TypeOf(uint(0)),
+	"uint8":      reflect.TypeOf(uint8(0)),
+	"uint16":     reflect.TypeOf(uint16(0)),
+	"uint32":     reflect.TypeOf(uint32(0)),
+	"uint64":     reflect.TypeOf(uint64(0)),
+	"uintptr":    reflect.TypeOf(uintptr(0)),
+	"float32":    reflect.TypeOf(float32(0)),
+	"float64":    reflect.TypeOf(float64(0)),
+	"complex64":  reflect.TypeOf(complex64(0)),
+	"complex128": reflect.TypeOf(complex128(0)),
+	"string":     reflect.TypeOf(""),
+	"byte":       reflect.TypeOf(byte(0)),
+	"rune":       reflect.TypeOf(rune(0)),
+}
+
+// evaluator evaluates side-effect free Go expressions with reflection.
+// Identifiers are resolved with vars, and qualified identifiers with pkgs,
+// which fails if the package name is ambiguous.
+type evaluator struct {
+	vars func(name string) (reflect.Value, bool)
+	pkgs func(name string) (map[string]reflect.Value, bool, error)
+}
+
+// newEvaluator returns an evaluator for the given frame. If the frame is nil,
+// only package symbols can be resolved.
+func (a *Adapter) newEvaluator(f *interp.DebugFrame) *evaluator {
+	e := new(evaluator)
+	e.vars = func(name string) (reflect.Value, bool) {
+		if f == nil {
+			return reflect.Value{}, false
+		}
+		for _, sc := range f.Scopes() {
+			for _, v := range sc.Variables() {
+				if v.Name == name {
+					return v.Value, true
+				}
+			}
+		}
+		return reflect.Value{}, false
+	}
+	e.pkgs = func(name string) (map[string]reflect.Value, bool, error) {
+		if a.interp == nil {
+			return nil, false, nil
+		}
+		paths := a.importPaths(f, name)
+		switch len(paths) {
+		case 0:
+			return nil, false, nil
+		case 1:
+			syms, ok := a.packageSymbols(paths[0])
+			return syms, ok, nil
+		}
+		return nil, false, fmt.Errorf("ambiguous package %s: %s", name, strings.Join(paths, ", "))
+	}
+	return e
+}
+
+// importPaths returns the sorted import paths of the packages named name,
+// see frameImports.
+func (a *Adapter) importPaths(f *interp.DebugFrame, name string) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, imports := range a.frameImports(f) {
+		if p, ok := imports[name]; ok && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// frameImports returns the imports of the file of the frame, see fileImports.
+// Without a frame, or if the file of the frame cannot be read, the imports of
+// every compiled file are returned.
+func (a *Adapter) frameImports(f *interp.DebugFrame) []map[string]string {
+	if f != nil {
+		if b := a.frameText(f); b != nil {
+			return []map[string]string{fileImports(b)}
+		}
+	}
+	var imports []map[string]string
+	for _, src := range a.compiledSources() {
+		imports = append(imports, fileImports(src.text))
+	}
+	return imports
+}
+
+// packageSymbols returns the exported symbols of the package. The symbols of
+// generic functions of interpreted packages cannot be generated, and the
+// interpreter panics on them.
+func (a *Adapter) packageSymbols(importPath string) (syms map[string]reflect.Value, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			syms, ok = nil, false
+		}
+	}()
+	syms, ok = a.interp.Symbols(importPath)[importPath]
+	return syms, ok
+}
+
+// fileImports returns the import paths of the source file by package name.
+// A package imported without a name is named after the base of its path.
+func fileImports(src []byte) map[string]string {
+	imports := map[string]string{}
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+	if err != nil {
+		return imports
+	}
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name != "_" && name != "." {
+			imports[name] = p
+		}
+	}
+	return imports
+}
+
+// evaluate evaluates expr in the scope of the frame.
+func (a *Adapter) evaluate(f *interp.DebugFrame, expr string) (reflect.Value, error) {
+	return a.newEvaluator(f).Eval(expr)
+}
+
+// Eval parses and evaluates expr. A nil result is returned as the zero Value.
+func (e *evaluator) Eval(expr string) (reflect.Value, error) {
+	x, err := parser.ParseExpr(expr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	rv, _, err := e.eval(x)
+	return rv, err
+}
+
+// EvalAs parses and evaluates expr, and converts the result to t as in an
+// assignment.
+func (e *evaluator) EvalAs(expr string, t reflect.Type) (reflect.Value, error) {
+	x, err := parser.ParseExpr(expr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	rv, untyped, err := e.eval(x)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	rv, ok := unrestricted(rv)
+	if !ok {
+		return reflect.Value{}, errors.New("cannot use a value obtained from an unexported field")
+	}
+	return convert(rv, untyped, t)
+}
+
+// Assign evaluates value and assigns it to the operand expr, which must be
+// addressable or a map index expression. It returns the new value of expr.
+func (e *evaluator) Assign(expr, value string) (reflect.Value, error) {
+	x, err := parser.ParseExpr(expr)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	switch x := unparen(x).(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.StarExpr:
+	case *ast.IndexExpr:
+		m, _, err := e.eval(x.X)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		for m.Kind() == rInterface && !m.IsNil() {
+			m = m.Elem()
+		}
+		if m.Kind() == rMap {
+			return e.assignMapIndex(m, x.Index, value)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot assign to %s", expr)
+	}
+
+	v, _, err := e.eval(x)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("cannot assign to %s", expr)
+	}
+	sv, ok := unrestricted(v)
+	if !ok || !sv.CanSet() {
+		return reflect.Value{}, fmt.Errorf("cannot assign to %s (value of type %s is not addressable)", expr, v.Type())
+	}
+
+	nv, err := e.EvalAs(value, v.Type())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	sv.Set(nv)
+	return sv, nil
+}
+
+func (e *evaluator) assignMapIndex(m reflect.Value, key ast.Expr, value string) (reflect.Value, error) {
+	if m.IsNil() {
+		return reflect.Value{}, errors.New("assignment to entry in nil map")
+	}
+	m, ok := unrestricted(m)
+	if !ok {
+		return reflect.Value{}, errors.New("cannot assign to a map obtained from an unexported field")
+	}
+
+	k, ku, err := e.eval(key)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if k, ok = unrestricted(k); !ok {
+		return reflect.Value{}, errors.New("cannot use a value obtained from an unexported field")
+	}
+	if k, err = convert(k, ku, m.Type().Key()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	nv, err := e.EvalAs(value, m.Type().Elem())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	m.SetMapIndex(k, nv)
+	return m.MapIndex(k), nil
+}
+
+func unparen(x ast.Expr) ast.Expr {
+	for {
+		p, ok := x.(*ast.ParenExpr)
+		if !ok {
+			return x
+		}
+		x = p.X
+	}
+}
+
+// eval evaluates x. The untyped result reports whether x is an untyped
+// constant, whose type may still be converted to match the other operand.
+//
+//nolint:gocyclo // one case per expression type
+func (e *evaluator) eval(x ast.Expr) (rv reflect.Value, untyped bool, err error) {
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return e.eval(x.X)
+
+	case *ast.BasicLit:
+		return basicLit(x)
+
+	case *ast.Ident:
+		if v, ok := e.vars(x.Name); ok {
+			return v, false, nil
+		}
+		switch x.Name {
+		case "true", "false":
+			return reflect.ValueOf(x.Name == "true"), true, nil
+		case "nil":
+			return reflect.Value{}, true, nil
+		}
+		return reflect.Value{}, false, fmt.Errorf("undefined: %s", x.Name)
+
+	case *ast.SelectorExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			if _, isVar := e.vars(id.Name); !isVar {
+				syms, ok, err := e.pkgs(id.Name)
+				if err != nil {
+					return reflect.Value{}, false, err
+				}
+				if ok {
+					v, ok := syms[x.Sel.Name]
+					if !ok {
+						return reflect.Value{}, false, fmt.Errorf("undefined: %s.%s", id.Name, x.Sel.Name)
+					}
+					return v, false, nil
+				}
+			}
+		}
+		v, _, err := e.eval(x.X)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		v, err = selectField(v, x.Sel.Name)
+		return v, false, err
+
+	case *ast.StarExpr:
+		v, _, err := e.eval(x.X)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		if v.Kind() != rPtr {
+			return reflect.Value{}, false, fmt.Errorf("invalid indirect of %s", typeString(v))
+		}
+		if v.IsNil() {
+			return reflect.Value{}, false, errors.New("nil pointer dereference")
+		}
+		return v.Elem(), false, nil
+
+	case *ast.IndexExpr:
+		v, _, err := e.eval(x.X)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		i, iu, err := e.eval(x.Index)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		v, err = index(v, i, iu)
+		return v, false, err
+
+	case *ast.SliceExpr:
+		return e.slice(x)
+
+	case *ast.UnaryExpr:
+		v, u, err := e.eval(x.X)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		v, err = unary(x.Op, v)
+		return v, u, err
+
+	case *ast.BinaryExpr:
+		return e.binary(x)
+
+	case *ast.CallExpr:
+		return e.call(x)
+
+	default:
+		return reflect.Value{}, false, fmt.Errorf("unsupported expression %T", x)
+	}
+}
+
+func basicLit(x *ast.BasicLit) (reflect.Value, bool, error) {
+	c := constant.MakeFromLiteral(x.Value, x.Kind, 0)
+	switch c.Kind() {
+	case constant.Int:
+		if x.Kind == token.CHAR {
+			v, _ := constant.Int64Val(c)
+			return reflect.ValueOf(rune(v)), true, nil
+		}
+		if v, exact := constant.Int64Val(c); exact {
+			return reflect.ValueOf(int(v)), true, nil
+		}
+		if v, exact := constant.Uint64Val(c); exact {
+			return reflect.ValueOf(v), true, nil
+		}
+		return reflect.Value{}, false, fmt.Errorf("constant %s overflows", x.Value)
+	case constant.Float:
+		v, _ := constant.Float64Val(c)
+		return reflect.ValueOf(v), true, nil
+	case constant.Complex:
+		re, _ := constant.Float64Val(constant.Real(c))
+		im, _ := constant.Float64Val(constant.Imag(c))
+		return reflect.ValueOf(complex(re, im)), true, nil
+	case constant.String:
+		return reflect.ValueOf(constant.StringVal(c)), true, nil
+	default:
+		return reflect.Value{}, false, fmt.Errorf("invalid literal %s", x.Value)
+	}
+}
+
+// selectField returns the named field or method of v, following pointers
+// and interfaces.
+func selectField(v reflect.Value, name string) (reflect.Value, error) {
+	for {
+		if !v.IsValid() {
+			return reflect.Value{}, fmt.Errorf("nil has no field or method %s", name)
+		}
+		k := v.Kind()
+		if (k == rPtr || k == rInterface) && v.IsNil() {
+			return reflect.Value{}, errors.New("nil pointer dereference")
+		}
+		if m := v.MethodByName(name); m.IsValid() {
+			return m, nil
+		}
+		if k != rPtr && k != rInterface {
+			break
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == rStruct {
+		if f := v.FieldByName(name); f.IsValid() {
+			return f, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s has no field or method %s", v.Type(), name)
+}
+
+func index(v, i reflect.Value, untyped bool) (reflect.Value, error) {
+	for v.Kind() == rInterface || (v.Kind() == rPtr && v.Type().Elem().Kind() == rArray) {
+		if v.IsNil() {
+			return reflect.Value{}, errors.New("nil pointer dereference")
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case rMap:
+		k, err := convert(i, untyped, v.Type().Key())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if e := v.MapIndex(k); e.IsValid() {
+			return e, nil
+		}
+		return reflect.Zero(v.Type().Elem()), nil
+
+	case rArray, rSlice, rString:
+		n, err := toInt(i)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if n < 0 || n >= v.Len() {
+			return reflect.Value{}, fmt.Errorf("index out of range [%d] with length %d", n, v.Len())
+		}
+		return v.Index(n), nil
+
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot index %s", typeString(v))
+	}
+}
+
+func (e *evaluator) slice(x *ast.SliceExpr) (reflect.Value, bool, error) {
+	v, _, err := e.eval(x.X)
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+	if v.Kind() == rPtr && v.Type().Elem().Kind() == rArray && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case rSlice, rString:
+	case rArray:
+		if !v.CanAddr() {
+			return reflect.Value{}, false, errors.New("cannot slice unaddressable array")
+		}
+	default:
+		return reflect.Value{}, false, fmt.Errorf("cannot slice %s", typeString(v))
+	}
+
+	limit := v.Len()
+	if v.Kind() != rString {
+		limit = v.Cap()
+	} else if x.Slice3 {
+		return reflect.Value{}, false, errors.New("3-index slice of string")
+	}
+
+	bounds := []int{0, v.Len(), limit}
+	for i, b := range []ast.Expr{x.Low, x.High, x.Max} {
+		if b == nil {
+			continue
+		}
+		bv, _, err := e.eval(b)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		if bounds[i], err = toInt(bv); err != nil {
+			return reflect.Value{}, false, err
+		}
+	}
+	if bounds[0] < 0 || bounds[0] > bounds[1] || bounds[1] > bounds[2] || bounds[2] > limit {
+		return reflect.Value{}, false, fmt.Errorf("slice bounds out of range [%d:%d:%d]", bounds[0], bounds[1], bounds[2])
+	}
+	if x.Slice3 {
+		return v.Slice3(bounds[0], bounds[1], bounds[2]), false, nil
+	}
+	return v.Slice(bounds[0], bounds[1]), false, nil
+}
+
+func unary(op token.Token, v reflect.Value) (reflect.Value, error) {
+	if op == token.AND {
+		if !v.CanAddr() {
+			return reflect.Value{}, fmt.Errorf("cannot take the address of %s", typeString(v))
+		}
+		return v.Addr(), nil
+	}
+	if !v.IsValid() {
+		return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on nil", op)
+	}
+
+	r := reflect.New(v.Type()).Elem()
+	switch k := v.Kind(); {
+	case op == token.ADD && (isInt(k) || isUint(k) || isFloat(k) || isComplex(k)):
+		r.Set(v)
+	case op == token.SUB && isInt(k):
+		r.SetInt(-v.Int())
+	case op == token.SUB && isUint(k):
+		r.SetUint(-v.Uint())
+	case op == token.SUB && isFloat(k):
+		r.SetFloat(-v.Float())
+	case op == token.SUB && isComplex(k):
+		r.SetComplex(-v.Complex())
+	case op == token.XOR && isInt(k):
+		r.SetInt(^v.Int())
+	case op == token.XOR && isUint(k):
+		r.SetUint(^v.Uint())
+	case op == token.NOT && k == rBool:
+		r.SetBool(!v.Bool())
+	default:
+		return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on %s", op, typeString(v))
+	}
+	return r, nil
+}
+
+func (e *evaluator) binary(x *ast.BinaryExpr) (reflect.Value, bool, error) {
+	l, lu, err := e.eval(x.X)
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+
+	if x.Op == token.LAND || x.Op == token.LOR {
+		if l.Kind() != rBool {
+			return reflect.Value{}, false, fmt.Errorf("invalid operation: operator %s not defined on %s", x.Op, typeString(l))
+		}
+		// short-circuit evaluation
+		if l.Bool() == (x.Op == token.LOR) {
+			return l, lu, nil
+		}
+		r, ru, err := e.eval(x.Y)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		if r.Kind() != rBool {
+			return reflect.Value{}, false, fmt.Errorf("invalid operation: operator %s not defined on %s", x.Op, typeString(r))
+		}
+		return r, lu && ru, nil
+	}
+
+	r, ru, err := e.eval(x.Y)
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+
+	if x.Op == token.SHL || x.Op == token.SHR {
+		s, err := toInt(r)
+		if err != nil || s < 0 {
+			return reflect.Value{}, false, fmt.Errorf("invalid shift count %s", typeString(r))
+		}
+		v, err := shift(x.Op, l, uint(s))
+		if err == nil && lu {
+			v, err = exactConstant(x.Op, l, reflect.ValueOf(s), v)
+		}
+		return v, lu, err
+	}
+
+	// untyped operands take the type of the other operand
+	switch {
+	case lu && !ru && r.IsValid():
+		l, err = convert(l, true, r.Type())
+	case ru && !lu && l.IsValid():
+		r, err = convert(r, true, l.Type())
+	case lu && ru && l.IsValid() && r.IsValid() && l.Kind() != r.Kind():
+		l, r, err = unifyUntyped(l, r)
+	}
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+
+	switch x.Op {
+	case token.EQL, token.NEQ:
+		eq, err := equal(l, r)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		return reflect.ValueOf(eq == (x.Op == token.EQL)), lu && ru, nil
+
+	case token.LSS, token.LEQ, token.GTR, token.GEQ:
+		c, err := compare(l, r)
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
+		var b bool
+		switch x.Op {
+		case token.LSS:
+			b = c < 0
+		case token.LEQ:
+			b = c <= 0
+		case token.GTR:
+			b = c > 0
+		default:
+			b = c >= 0
+		}
+		return reflect.ValueOf(b), lu && ru, nil
+
+	default:
+		v, err := arith(x.Op, l, r)
+		if err == nil && lu && ru {
+			v, err = exactConstant(x.Op, l, r, v)
+		}
+		return v, lu && ru, err
+	}
+}
+
+// call evaluates calls of the len and cap builtins, and conversions to
+// predeclared types. Function calls are not supported as they could block the
+// adapter or modify the state of the program.
+func (e *evaluator) call(x *ast.CallExpr) (reflect.Value, bool, error) {
+	id, ok := x.Fun.(*ast.Ident)
+	if !ok || len(x.Args) != 1 {
+		return reflect.Value{}, false, errors.New("function calls are not supported")
+	}
+	if _, isVar := e.vars(id.Name); isVar {
+		return reflect.Value{}, false, errors.New("function calls are not supported")
+	}
+
+	v, untyped, err := e.eval(x.Args[0])
+	if err != nil {
+		return reflect.Value{}, false, err
+	}
+
+	switch id.Name {
+	case "len", "cap":
+		if v.Kind() == rPtr && v.Type().Elem().Kind() == rArray {
+			v = v.Elem()
+		}
+		switch k := v.Kind(); {
+		case k == rArray || k == rChan || k == rSlice:
+		case id.Name == "len" && (k == rMap || k == rString):
+		default:
+			return reflect.Value{}, false, fmt.Errorf("invalid argument %s for %s", typeString(v), id.Name)
+		}
+		if id.Name == "len" {
+			return reflect.ValueOf(v.Len()), false, nil
+		}
+		return reflect.ValueOf(v.Cap()), false, nil
+	}
+
+	if t, ok := basicTypes[id.Name]; ok {
+		if !v.IsValid() || !v.Type().ConvertibleTo(t) {
+			return reflect.Value{}, false, fmt.Errorf("cannot convert %s to %s", typeString(v), t)
+		}
+		if untyped && overflows(v, t) {
+			return reflect.Value{}, false, fmt.Errorf("constant %v overflows %s", v, t)
+		}
+		return v.Convert(t), false, nil
+	}
+	return reflect.Value{}, false, errors.New("function calls are not supported")
+}
+
+// convert converts v to t. Untyped nil is converted to the zero value of
+// nillable types.
+func convert(v reflect.Value, untyped bool, t reflect.Type) (reflect.Value, error) {
+	if !v.IsValid() {
+		if untyped && canBeNil(t.Kind()) {
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use nil as %s value", t)
+	}
+	if v.Type() == t {
+		return v, nil
+	}
+	if v.Type().AssignableTo(t) {
+		r := reflect.New(t).Elem()
+		r.Set(v)
+		return r, nil
+	}
+	if !untyped || !v.Type().ConvertibleTo(t) {
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s value", typeString(v), t)
+	}
+	// only numeric constants convert between numeric types, and only string
+	// constants convert to strings
+	vk, tk := v.Kind(), t.Kind()
+	if (tk == rString) != (vk == rString) || tk == rBool != (vk == rBool) {
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s value", typeString(v), t)
+	}
+	if isFloat(vk) && (isInt(tk) || isUint(tk)) && v.Float() != math.Trunc(v.Float()) {
+		return reflect.Value{}, fmt.Errorf("constant %v truncated to %s", v, t)
+	}
+	if overflows(v, t) {
+		return reflect.Value{}, fmt.Errorf("constant %v overflows %s", v, t)
+	}
+	return v.Convert(t), nil
+}
+
+// overflows reports whether the numeric constant v cannot be represented by
+// a value of type t.
+func overflows(v reflect.Value, t reflect.Type) bool {
+	z := reflect.Zero(t)
+	switch vk, tk := v.Kind(), t.Kind(); {
+	case isInt(tk) && isInt(vk):
+		return z.OverflowInt(v.Int())
+	case isInt(tk) && isUint(vk):
+		return v.Uint() > math.MaxInt64 || z.OverflowInt(int64(v.Uint()))
+	case isInt(tk) && isFloat(vk):
+		return v.Float() < math.MinInt64 || v.Float() >= math.MaxInt64 || z.OverflowInt(int64(v.Float()))
+	case isUint(tk) && isInt(vk):
+		return v.Int() < 0 || z.OverflowUint(uint64(v.Int()))
+	case isUint(tk) && isUint(vk):
+		return z.OverflowUint(v.Uint())
+	case isUint(tk) && isFloat(vk):
+		return v.Float() < 0 || v.Float() >= math.MaxUint64 || z.OverflowUint(uint64(v.Float()))
+	case isFloat(tk) && isFloat(vk):
+		return z.OverflowFloat(v.Float())
+	}
+	return false
+}
+
+// exactConstant returns the result v of the operation on the untyped
+// integer constants l and r, or the exact result if v wrapped. Like literals,
+// integer constants are represented with int values, or uint64 values if
+// they overflow int.
+func exactConstant(op token.Token, l, r, v reflect.Value) (reflect.Value, error) {
+	c := func(v reflect.Value) (constant.Value, bool) {
+		switch k := v.Kind(); {
+		case isInt(k):
+			return constant.MakeInt64(v.Int()), true
+		case isUint(k):
+			return constant.MakeUint64(v.Uint()), true
+		}
+		return nil, false
+	}
+	a, aok := c(l)
+	b, bok := c(r)
+	got, ok := c(v)
+	if !aok || !bok || !ok {
+		return v, nil
+	}
+
+	var want constant.Value
+	switch op {
+	case token.SHL, token.SHR:
+		s, _ := constant.Uint64Val(b)
+		want = constant.Shift(a, op, uint(s))
+	case token.QUO:
+		want = constant.BinaryOp(a, token.QUO_ASSIGN, b)
+	default:
+		want = constant.BinaryOp(a, op, b)
+	}
+	switch {
+	case constant.Compare(got, token.EQL, want):
+		return v, nil
+	case v.Kind() == rInt:
+		if u, exact := constant.Uint64Val(want); exact {
+			return reflect.ValueOf(u), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("constant %s overflows %s", want, v.Type())
+}
+
+func unifyUntyped(l, r reflect.Value) (reflect.Value, reflect.Value, error) {
+	rank := func(k reflect.Kind) int {
+		switch {
+		case isComplex(k):
+			return 3
+		case isFloat(k):
+			return 2
+		case isInt(k) || isUint(k):
+			return 1
+		}
+		return 0
+	}
+	lr, rr := rank(l.Kind()), rank(r.Kind())
+	if lr == 0 || rr == 0 {
+		return l, r, nil
+	}
+	if lr < rr {
+		return l.Convert(r.Type()), r, nil
+	}
+	return l, r.Convert(l.Type()), nil
+}
+
+func equal(l, r reflect.Value) (eq bool, err error) {
+	switch {
+	case !l.IsValid() && !r.IsValid():
+		return true, nil
+	case !l.IsValid():
+		return isNil(r)
+	case !r.IsValid():
+		return isNil(l)
+	}
+
+	if l.Type() != r.Type() && l.Kind() != rInterface && r.Kind() != rInterface {
+		return false, fmt.Errorf("invalid operation: mismatched types %s and %s", l.Type(), r.Type())
+	}
+	if !l.Type().Comparable() || !r.Type().Comparable() {
+		return false, fmt.Errorf("invalid operation: %s cannot be compared", l.Type())
+	}
+	if !l.CanInterface() || !r.CanInterface() {
+		if l.Kind() != r.Kind() {
+			return false, nil
+		}
+		c, err := compare(l, r)
+		if err != nil {
+			return false, fmt.Errorf("invalid operation: %s cannot be compared", l.Type())
+		}
+		return c == 0, nil
+	}
+	for _, v := range []reflect.Value{l, r} {
+		if v.Kind() == rInterface && !v.IsNil() && !v.Elem().Type().Comparable() {
+			return false, fmt.Errorf("comparing uncomparable type %s", v.Elem().Type())
+		}
+	}
+
+	// values nested in arrays and structs may still be uncomparable
+	defer func() {
+		if p := recover(); p != nil {
+			eq, err = false, fmt.Errorf("%v", p)
+		}
+	}()
+	return l.Interface() == r.Interface(), nil
+}
+
+func isNil(v reflect.Value) (bool, error) {
+	if !canBeNil(v.Kind()) {
+		return false, fmt.Errorf("invalid operation: mismatched types %s and untyped nil", v.Type())
+	}
+	return v.IsNil(), nil
+}
+
+func compare(l, r reflect.Value) (int, error) {
+	if !l.IsValid() || !r.IsValid() || l.Type() != r.Type() {
+		return 0, fmt.Errorf("invalid operation: mismatched types %s and %s", typeString(l), typeString(r))
+	}
+
+	switch k := l.Kind(); {
+	case isInt(k):
+		return cmp(l.Int(), r.Int()), nil
+	case isUint(k):
+		return cmp(l.Uint(), r.Uint()), nil
+	case isFloat(k):
+		return cmp(l.Float(), r.Float()), nil
+	case k == rString:
+		return cmp(l.String(), r.String()), nil
+	case k == rBool:
+		if l.Bool() == r.Bool() {
+			return 0, nil
+		}
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("invalid operation: %s is not ordered", l.Type())
+	}
+}
+
+func cmp[T int64 | uint64 | float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+//nolint:gocyclo // one case per operator and kind
+func arith(op token.Token, l, r reflect.Value) (reflect.Value, error) {
+	if !l.IsValid() || !r.IsValid() || l.Type() != r.Type() {
+		return reflect.Value{}, fmt.Errorf("invalid operation: mismatched types %s and %s", typeString(l), typeString(r))
+	}
+
+	v := reflect.New(l.Type()).Elem()
+	switch k := l.Kind(); {
+	case isInt(k):
+		a, b := l.Int(), r.Int()
+		if (op == token.QUO || op == token.REM) && b == 0 {
+			return reflect.Value{}, errors.New("integer divide by zero")
+		}
+		switch op {
+		case token.ADD:
+			v.SetInt(a + b)
+		case token.SUB:
+			v.SetInt(a - b)
+		case token.MUL:
+			v.SetInt(a * b)
+		case token.QUO:
+			v.SetInt(a / b)
+		case token.REM:
+			v.SetInt(a % b)
+		case token.AND:
+			v.SetInt(a & b)
+		case token.OR:
+			v.SetInt(a | b)
+		case token.XOR:
+			v.SetInt(a ^ b)
+		case token.AND_NOT:
+			v.SetInt(a &^ b)
+		default:
+			return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on %s", op, l.Type())
+		}
+
+	case isUint(k):
+		a, b := l.Uint(), r.Uint()
+		if (op == token.QUO || op == token.REM) && b == 0 {
+			return reflect.Value{}, errors.New("integer divide by zero")
+		}
+		switch op {
+		case token.ADD:
+			v.SetUint(a + b)
+		case token.SUB:
+			v.SetUint(a - b)
+		case token.MUL:
+			v.SetUint(a * b)
+		case token.QUO:
+			v.SetUint(a / b)
+		case token.REM:
+			v.SetUint(a % b)
+		case token.AND:
+			v.SetUint(a & b)
+		case token.OR:
+			v.SetUint(a | b)
+		case token.XOR:
+			v.SetUint(a ^ b)
+		case token.AND_NOT:
+			v.SetUint(a &^ b)
+		default:
+			return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on %s", op, l.Type())
+		}
+
+	case isFloat(k):
+		a, b := l.Float(), r.Float()
+		switch op {
+		case token.ADD:
+			v.SetFloat(a + b)
+		case token.SUB:
+			v.SetFloat(a - b)
+		case token.MUL:
+			v.SetFloat(a * b)
+		case token.QUO:
+			v.SetFloat(a / b)
+		default:
+			return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on %s", op, l.Type())
+		}
+
+	case isComplex(k):
+		a, b := l.Complex(), r.Complex()
+		switch op {
+		case token.ADD:
+			v.SetComplex(a + b)
+		case token.SUB:
+			v.SetComplex(a - b)
+		case token.MUL:
+			v.SetComplex(a * b)
+		case token.QUO:
+			v.SetComplex(a / b)
+		default:
+			return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on %s", op, l.Type())
+		}
+
+	case k == rString && op == token.ADD:
+		v.SetString(l.String() + r.String())
+
+	default:
+		return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on %s", op, l.Type())
+	}
+	return v, nil
+}
+
+func shift(op token.Token, v reflect.Value, s uint) (reflect.Value, error) {
+	r := reflect.New(v.Type()).Elem()
+	switch k := v.Kind(); {
+	case isInt(k) && op == token.SHL:
+		r.SetInt(v.Int() << s)
+	case isInt(k):
+		r.SetInt(v.Int() >> s)
+	case isUint(k) && op == token.SHL:
+		r.SetUint(v.Uint() << s)
+	case isUint(k):
+		r.SetUint(v.Uint() >> s)
+	default:
+		return reflect.Value{}, fmt.Errorf("invalid operation: shift of type %s", typeString(v))
+	}
+	return r, nil
+}
+
+func toInt(v reflect.Value) (int, error) {
+	switch k := v.Kind(); {
+	case isInt(k):
+		return int(v.Int()), nil
+	case isUint(k):
+		return int(v.Uint()), nil
+	}
+	return 0, fmt.Errorf("invalid index type %s", typeString(v))
+}
+
+func typeString(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+func isInt(k reflect.Kind) bool {
+	return k == rInt || k == rInt8 || k == rInt16 || k == rInt32 || k == rInt64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k == rUint || k == rUint8 || k == rUint16 || k == rUint32 || k == rUint64 || k == rUintptr
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == rFloat32 || k == rFloat64
+}
+
+func isComplex(k reflect.Kind) bool {
+	return k == rComplex64 || k == rComplex128
+}
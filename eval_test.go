@@ -0,0 +1,364 @@
+package dbg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+type evalPoint struct {
+	X, Y int
+	Tags map[string]int
+}
+
+func newTestEvaluator(vars map[string]interface{}) *evaluator {
+	return &evaluator{
+		vars: func(name string) (reflect.Value, bool) {
+			v, ok := vars[name]
+			if !ok {
+				return reflect.Value{}, false
+			}
+			return reflect.ValueOf(v).Elem(), true
+		},
+		pkgs: func(name string) (map[string]reflect.Value, bool, error) {
+			switch name {
+			case "strconv":
+				return map[string]reflect.Value{"IntSize": reflect.ValueOf(64)}, true, nil
+			case "rand":
+				return nil, false, errors.New("ambiguous package rand: crypto/rand, math/rand")
+			}
+			return nil, false, nil
+		},
+	}
+}
+
+func Test_evaluator_Eval(t *testing.T) {
+	i := 42
+	f := 1.5
+	s := "yaegi"
+	ints := []int{1, 2, 3}
+	p := &evalPoint{X: 3, Y: 4, Tags: map[string]int{"a": 1}}
+	var err error
+	e := newTestEvaluator(map[string]interface{}{
+		"i": &i, "f": &f, "s": &s, "ints": &ints, "p": &p, "err": &err,
+	})
+
+	cases := []struct {
+		expr string
+		out  string
+	}{
+		{"i", "42"},
+		{"i + 1", "43"},
+		{"-i / 5", "-8"},
+		{"i % 5 == 2", "true"},
+		{"f * 2", "3"},
+		{"i > 40 && f < 2", "true"},
+		{"!(i == 42)", "false"},
+		{"s + \"!\"", "yaegi!"},
+		{"s[1:3]", "ae"},
+		{"len(s)", "5"},
+		{"ints[2]", "3"},
+		{"ints[1:]", "[2 3]"},
+		{"cap(ints[:1])", "3"},
+		{"p.X * p.Y", "12"},
+		{"(*p).Y", "4"},
+		{"p.Tags[\"a\"]", "1"},
+		{"p.Tags[\"b\"]", "0"},
+		{"err == nil", "true"},
+		{"p != nil", "true"},
+		{"float64(i) / 8", "5.25"},
+		{"1 << 4", "16"},
+		{"'a'", "97"},
+		{"strconv.IntSize", "64"},
+	}
+	for _, each := range cases {
+		t.Run(each.expr, func(t *testing.T) {
+			rv, err := e.Eval(each.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprint(rv), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func Test_evaluator_Eval_errors(t *testing.T) {
+	i := 42
+	ints := []int{1}
+	var x, y interface{} = []int{1}, []int{1}
+	type pair struct{ A interface{} }
+	p, q := pair{ints}, pair{ints}
+	e := newTestEvaluator(map[string]interface{}{"i": &i, "ints": &ints, "x": &x, "y": &y, "p": &p, "q": &q})
+
+	cases := []string{
+		"j",
+		"i +",
+		"i / 0",
+		"ints[1]",
+		"i.X",
+		"i == \"x\"",
+		"fmt.Println(i)",
+		"x == y",
+		"x != nil && x == ints",
+		"p == q",
+		"rand.Int",
+	}
+	for _, expr := range cases {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := e.Eval(expr); err == nil {
+				t.Errorf("expected an error for %q", expr)
+			}
+		})
+	}
+}
+
+func Test_evaluator_Eval_overflow(t *testing.T) {
+	var b uint8 = 44
+	var u uint = 1
+	var i8 int8 = 1
+	var f32 float32 = 1
+	e := newTestEvaluator(map[string]interface{}{"b": &b, "u": &u, "i8": &i8, "f32": &f32})
+
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{"b == 300", "constant 300 overflows uint8"},
+		{"u == -1", "constant -1 overflows uint"},
+		{"i8 + 200", "constant 200 overflows int8"},
+		{"f32 < 1e300", "constant 1e+300 overflows float32"},
+		{"1 << 70", "constant 1180591620717411303424 overflows int"},
+		{"18446744073709551615 + 1", "constant 18446744073709551616 overflows uint64"},
+		{"uint8(256)", "constant 256 overflows uint8"},
+		{"int(1e20)", "constant 1e+20 overflows int"},
+	}
+	for _, each := range cases {
+		t.Run(each.expr, func(t *testing.T) {
+			_, err := e.Eval(each.expr)
+			if err == nil {
+				t.Fatalf("expected an error for %q", each.expr)
+			}
+			if got, want := err.Error(), each.err; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+
+	// constants that fit are converted
+	for _, expr := range []string{"b == 44", "u < 1 << 63", "i8 + 126 == 127", "uint8(255) == 255"} {
+		rv, err := e.Eval(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if !rv.Bool() {
+			t.Errorf("%s is false", expr)
+		}
+	}
+}
+
+func Test_evaluator_Assign(t *testing.T) {
+	i := 42
+	ints := []int{1, 2, 3}
+	p := &evalPoint{X: 3, Y: 4, Tags: map[string]int{"a": 1}}
+	e := newTestEvaluator(map[string]interface{}{"i": &i, "ints": &ints, "p": &p})
+
+	cases := []struct {
+		expr  string
+		value string
+		out   string
+	}{
+		{"i", "i * 2", "84"},
+		{"ints[0]", "-1", "-1"},
+		{"(p.X)", "p.Y + 1", "5"},
+		{"p.Tags[\"a\"]", "7", "7"},
+		{"p.Tags[\"b\"]", "8", "8"},
+	}
+	for _, each := range cases {
+		t.Run(each.expr, func(t *testing.T) {
+			rv, err := e.Assign(each.expr, each.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprint(rv), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+	if i != 84 || ints[0] != -1 || p.X != 5 || p.Tags["a"] != 7 || p.Tags["b"] != 8 {
+		t.Errorf("values not assigned: %v %v %v", i, ints, p)
+	}
+}
+
+func Test_evaluator_Assign_errors(t *testing.T) {
+	i := 42
+	var m map[string]int
+	e := newTestEvaluator(map[string]interface{}{"i": &i, "m": &m})
+
+	cases := []struct {
+		expr  string
+		value string
+	}{
+		{"i + 1", "2"},
+		{"i", `"x"`},
+		{"j", "1"},
+		{"m[\"a\"]", "1"},
+		{"nil", "1"},
+	}
+	for _, each := range cases {
+		t.Run(each.expr, func(t *testing.T) {
+			if _, err := e.Assign(each.expr, each.value); err == nil {
+				t.Errorf("expected an error assigning %s to %s", each.value, each.expr)
+			}
+		})
+	}
+}
+
+func Test_evaluator_Assign_overflow(t *testing.T) {
+	var b uint8 = 44
+	var x uint = 1
+	e := newTestEvaluator(map[string]interface{}{"b": &b, "x": &x})
+
+	cases := []struct {
+		expr  string
+		value string
+		err   string
+	}{
+		{"b", "300", "constant 300 overflows uint8"},
+		{"x", "-1", "constant -1 overflows uint"},
+		{"b", "2.5", "constant 2.5 truncated to uint8"},
+	}
+	for _, each := range cases {
+		t.Run(each.expr+"="+each.value, func(t *testing.T) {
+			_, err := e.Assign(each.expr, each.value)
+			if err == nil {
+				t.Fatalf("expected an error assigning %s to %s", each.value, each.expr)
+			}
+			if got, want := err.Error(), each.err; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+
+			_, err = e.EvalAs(each.value, reflect.TypeOf(b))
+			if err == nil {
+				t.Errorf("expected an error converting %s", each.value)
+			}
+		})
+	}
+	if b != 44 || x != 1 {
+		t.Errorf("values assigned: %v %v", b, x)
+	}
+}
+
+func Test_fileImports(t *testing.T) {
+	src := []byte(`package main
+
+import (
+	"fmt"
+	crand "crypto/rand"
+	_ "embed"
+	. "math"
+	"math/rand"
+)
+`)
+	want := map[string]string{"fmt": "fmt", "crand": "crypto/rand", "rand": "math/rand"}
+	if got := fileImports(src); !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
+
+func TestAdapter_evaluate_packages(t *testing.T) {
+	src := `package main
+
+import (
+	crand "crypto/rand"
+	"math/rand"
+)
+
+func main() {
+	_, _ = crand.Int, rand.Int
+	println()
+}
+`
+	newInterpreter := func(opts interp.Options) (*interp.Interpreter, error) {
+		i := interp.New(opts)
+		return i, i.Use(stdlib.Symbols)
+	}
+	c := newTestClient(t, NewEvalAdapter(src, &Options{NewInterpreter: newInterpreter}))
+	c.launch(&dap.SourceBreakpoint{Line: 10})
+	stop := c.stopped()
+	trace := c.request(&dap.StackTraceArguments{ThreadId: stop.ThreadId.Get()}).Body.(*dap.StackTraceResponseBody)
+	frame := dap.Int(trace.StackFrames[0].Id)
+
+	// packages are resolved with the imports of the file of the frame
+	cases := []struct {
+		expr string
+		typ  string
+	}{
+		{"rand.Int", "func() int"},
+		{"crand.Int", "func(io.Reader, *big.Int) (*big.Int, error)"},
+	}
+	for _, each := range cases {
+		r := c.request(&dap.EvaluateArguments{Expression: each.expr, FrameId: frame})
+		if !r.Success {
+			t.Fatalf("evaluate %s failed: %s", each.expr, r.Message.GetOr(""))
+		}
+		if got, want := r.Body.(*dap.EvaluateResponseBody).Type.GetOr(""), each.typ; got != want {
+			t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+		}
+	}
+
+	// without a frame, packages are resolved with the imports of the program
+	r := c.request(&dap.EvaluateArguments{Expression: "rand.Int"})
+	if !r.Success {
+		t.Fatalf("evaluate rand.Int failed: %s", r.Message.GetOr(""))
+	}
+	if got, want := r.Body.(*dap.EvaluateResponseBody).Type.GetOr(""), "func() int"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if r := c.request(&dap.EvaluateArguments{Expression: "big.NewInt"}); r.Success {
+		t.Error("evaluated a package that the program does not import")
+	}
+
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_evaluate_overflow(t *testing.T) {
+	src := `package main
+
+func main() {
+	var b uint8 = 44
+	_ = b
+	println()
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.launch(&dap.SourceBreakpoint{Line: 6})
+	stop := c.stopped()
+	trace := c.request(&dap.StackTraceArguments{ThreadId: stop.ThreadId.Get()}).Body.(*dap.StackTraceResponseBody)
+	frame := dap.Int(trace.StackFrames[0].Id)
+
+	for _, context := range []string{"watch", "hover"} {
+		for _, expr := range []string{"b == 300", "b + 300"} {
+			r := c.request(&dap.EvaluateArguments{Expression: expr, FrameId: frame, Context: dap.Str(context)})
+			if r.Success {
+				t.Errorf("%s: %s evaluated to %s", context, expr, r.Body.(*dap.EvaluateResponseBody).Result)
+				continue
+			}
+			if got, want := r.Message.GetOr(""), "constant 300 overflows uint8"; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		}
+	}
+
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
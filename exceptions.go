@@ -0,0 +1,320 @@
+package dbg
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+)
+
+// Exception filters. Panics are caught on calls to panic in interpreted code:
+// runtime errors and panics raised by binary packages terminate the program.
+const (
+	panicFilter   = "panic"
+	recoverFilter = "recovered"
+)
+
+// exceptionLimits tells the client which panics the filters catch.
+const exceptionLimits = "Only calls to panic in interpreted code are caught: " +
+	"runtime errors, such as nil dereferences, and panics raised by binary packages are not"
+
+var exceptionFilters = []*dap.ExceptionBreakpointsFilter{
+	{
+		Filter:      panicFilter,
+		Label:       "Panics",
+		Description: dap.Str("Break when interpreted code calls panic. " + exceptionLimits + "."),
+	},
+	{
+		Filter:      recoverFilter,
+		Label:       "Recovered panics",
+		Description: dap.Str("Break when a panic caught by the Panics filter is recovered"),
+	},
+}
+
+// exceptionSites returns breakpoints on the calls to panic and recover in
+// src. Calls to recover are only included if withRecover is set.
+func exceptionSites(src []byte, withRecover bool) []*breakpoint {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil
+	}
+
+	var bps []*breakpoint
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		id, ok := call.Fun.(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		pos := fset.Position(call.Pos())
+		switch {
+		case id.Name == "panic" && len(call.Args) == 1:
+			arg := call.Args[0]
+			bps = append(bps, &breakpoint{
+				request:   interp.LineBreakpoint(pos.Line),
+				exception: panicFilter,
+				column:    pos.Column,
+				expr:      string(src[fset.Position(arg.Pos()).Offset:fset.Position(arg.End()).Offset]),
+			})
+		case id.Name == "recover" && len(call.Args) == 0 && withRecover:
+			bps = append(bps, &breakpoint{
+				request:   interp.LineBreakpoint(pos.Line),
+				exception: recoverFilter,
+				column:    pos.Column,
+			})
+		}
+		return true
+	})
+	return bps
+}
+
+// setExceptionBreakpoints enables the exception filters, and installs
+// breakpoints on the calls to panic and recover of every compiled source.
+// Calls to panic are tracked if any filter is enabled, so that recovered
+// panics can be told apart from calls to recover that return nil.
+func (a *Adapter) setExceptionBreakpoints(filters []string) {
+	a.breakpoints.SetFilters(filters)
+	enabled := a.breakpoints.Filter(panicFilter) || a.breakpoints.Filter(recoverFilter)
+
+	// sources that had breakpoints must be reinstalled to remove them
+	install := map[string]bool{}
+	for _, source := range a.breakpoints.ExceptionSources() {
+		install[source] = true
+	}
+
+	for _, src := range a.compiledSources() {
+		var bps []*breakpoint
+		if enabled {
+			bps = exceptionSites(src.text, a.breakpoints.Filter(recoverFilter))
+		}
+		a.breakpoints.SetExceptions(src.path, bps)
+		if len(bps) > 0 {
+			install[src.path] = true
+		}
+	}
+
+	for source := range install {
+		a.installBreakpoints(source)
+	}
+}
+
+// exceptionHit records a call to panic or recover by the routine, and reports
+// whether it should stop. A call to panic that is not where its line breaks,
+// such as in "if err != nil { panic(err) }", is stepped to, see reachPanic.
+func (a *Adapter) exceptionHit(e *interp.DebugEvent, f *interp.DebugFrame, bp *breakpoint) bool {
+	id := e.GoRoutine()
+	switch bp.exception {
+	case panicFilter:
+		if pos := f.Position(); pos.Column != bp.column {
+			a.panicSteps.Add(id, e.FrameDepth(), pos, bp)
+			return false
+		}
+		ex := &exception{expr: bp.expr}
+		ex.value, ex.err = a.evaluate(f, bp.expr)
+		if ex.err != nil {
+			// calls cannot be evaluated again, but their result is kept
+			if v, ok := panicArgument(f); ok {
+				ex.value, ex.err = v, nil
+			}
+		}
+		if ex.err == nil && ex.value.Kind() == rInterface {
+			// the dynamic value is the one recovered
+			ex.value = ex.value.Elem()
+		}
+		a.exceptions.Set(id, ex)
+		return a.breakpoints.Filter(panicFilter)
+
+	case recoverFilter:
+		return a.exceptions.Recover(id) && a.breakpoints.Filter(recoverFilter)
+	}
+	return false
+}
+
+// panicArgument returns the argument of the call to panic that the frame is
+// stopped at. The interpreter evaluates it before the call, into the data of
+// the frame, which it does not expose: it is read through the unexported
+// fields of the frame. It reports false if the argument is not kept in the
+// data of the frame, e.g. a constant or a variable.
+func panicArgument(f *interp.DebugFrame) (v reflect.Value, ok bool) {
+	defer func() {
+		// the frame is not laid out as expected
+		if recover() != nil {
+			v, ok = reflect.Value{}, false
+		}
+	}()
+
+	frames := reflect.ValueOf(f).Elem().FieldByName("frames")
+	if frames.Len() == 0 {
+		return reflect.Value{}, false
+	}
+	frame := frames.Index(0).Elem()
+	debug := frame.FieldByName("debug")
+	if debug.IsNil() || debug.Elem().FieldByName("node").IsNil() {
+		return reflect.Value{}, false
+	}
+	call := debug.Elem().FieldByName("node").Elem()
+	child := call.FieldByName("child")
+	if child.Len() != 2 || child.Index(0).Elem().FieldByName("ident").String() != "panic" {
+		return reflect.Value{}, false
+	}
+
+	arg := child.Index(1).Elem()
+	i := int(arg.FieldByName("findex").Int())
+	data := frame.FieldByName("data")
+	if !arg.FieldByName("sym").IsNil() || arg.FieldByName("level").Int() != 0 || i < 0 || i >= data.Len() {
+		return reflect.Value{}, false
+	}
+	if rval, _ := unrestricted(arg.FieldByName("rval")); rval.Interface().(reflect.Value).IsValid() {
+		// a constant
+		return reflect.Value{}, false
+	}
+	slot, _ := unrestricted(data.Index(i))
+	return slot.Interface().(reflect.Value), true
+}
+
+// reachPanic handles a step of a routine stepping over its line to reach a
+// call to panic, and reports whether it stops there. The routine is stepped
+// further until it reaches a call to panic, or resumed once it leaves the
+// line.
+func (a *Adapter) reachPanic(e *interp.DebugEvent, s *panicStep) bool {
+	id := e.GoRoutine()
+	frames := e.Frames(0, 1)
+	if len(frames) == 0 {
+		a.panicSteps.Release(id)
+		return false
+	}
+	f := frames[0]
+	pos := f.Position()
+
+	if e.FrameDepth() != s.depth || pos.Line != s.line || pos.Filename != s.file {
+		// the calls to panic were not made
+		a.panicSteps.Release(id)
+		a.resume(id)
+		return false
+	}
+
+	for _, bp := range s.sites {
+		if bp.column == pos.Column {
+			a.panicSteps.Release(id)
+			if a.exceptionHit(e, f, bp) {
+				return true
+			}
+			a.resume(id)
+			return false
+		}
+	}
+
+	a.stepFrom(id, interp.DebugStepOver)
+	return false
+}
+
+// uncaughtPanic tells the client that the program was ended by a panic that
+// the exception filters may not have caught, see exceptionLimits.
+func (a *Adapter) uncaughtPanic(err error) {
+	var p interp.Panic
+	if !errors.As(err, &p) || !a.breakpoints.Filter(panicFilter) {
+		return
+	}
+	a.console("The program was ended by a panic: %v. %s.\n", p.Value, exceptionLimits)
+}
+
+// exceptionInfo describes the exception of the routine stopped by the event.
+// The exception ID is the type of the panic value, or "unknown" if the value
+// cannot be evaluated.
+func (a *Adapter) exceptionInfo(e *interp.DebugEvent, ex *exception) *dap.ExceptionInfoResponseBody {
+	b := &dap.ExceptionInfoResponseBody{
+		ExceptionId: "nil",
+		BreakMode:   dap.ExceptionBreakMode_Always,
+		Description: dap.Str(a.exceptionText(ex)),
+	}
+
+	d := &dap.ExceptionDetails{
+		EvaluateName: dap.Str(ex.expr),
+		Message:      b.Description,
+		StackTrace:   dap.Str(a.stackTraceText(e)),
+	}
+	b.Details = d
+
+	if ex.err != nil {
+		// neither evaluated nor kept in the frame, see panicArgument
+		b.ExceptionId = "unknown"
+		b.Description = dap.Str(fmt.Sprintf("The value of panic(%s) cannot be evaluated: %v", ex.expr, ex.err))
+		if ex.recovered {
+			b.BreakMode = dap.ExceptionBreakMode_UserUnhandled
+		}
+		return b
+	}
+	if ex.value.IsValid() {
+		t := ex.value.Type()
+		b.ExceptionId = t.String()
+		d.TypeName = dap.Str(t.String())
+		if t.PkgPath() != "" {
+			d.FullTypeName = dap.Str(t.PkgPath() + "." + t.Name())
+		}
+	}
+	if ex.recovered {
+		b.BreakMode = dap.ExceptionBreakMode_UserUnhandled
+	}
+	return b
+}
+
+// stackTraceText formats the frames of the event like a Go stack trace.
+// Unlike stackFrames, it does not register the frames.
+func (a *Adapter) stackTraceText(e *interp.DebugEvent) string {
+	var b strings.Builder
+	for _, f := range e.Frames(0, e.FrameDepth()) {
+		fmt.Fprintf(&b, "%s()\n", f.Name())
+		if src, pos := a.frameSource(f); src != nil {
+			fmt.Fprintf(&b, "\t%s:%d\n", src.Path.GetOr(""), pos.Line)
+		}
+	}
+	return b.String()
+}
+
+// exceptionText returns the text of the panic value of the exception: the
+// result of its Error or String method if any, see callStringMethod.
+func (a *Adapter) exceptionText(ex *exception) string {
+	switch {
+	case ex.err != nil:
+		return "unknown value of panic(" + ex.expr + ")"
+	case !ex.value.IsValid():
+		return "nil"
+	case ex.value.Kind() == rString:
+		return ex.value.String()
+	}
+	if s, ok := a.callStringMethod(ex.value); ok {
+		return s
+	}
+	return a.newValuePrinter(defaultValueLength).printString(ex.value)
+}
+
+// describe returns the result of the Error or String method of v.
+func describe(v reflect.Value) (s string, ok bool) {
+	if !v.CanInterface() {
+		return "", false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s, ok = "", false
+		}
+	}()
+
+	switch x := v.Interface().(type) {
+	case error:
+		return x.Error(), true
+	case fmt.Stringer:
+		return x.String(), true
+	}
+	return "", false
+}
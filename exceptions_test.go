@@ -0,0 +1,192 @@
+package dbg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+func Test_exceptionSites(t *testing.T) {
+	src := []byte(`package main
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			println(r)
+		}
+	}()
+	panic(fmt.Sprintf("bad %d", 42))
+}
+`)
+
+	bps := exceptionSites(src, false)
+	if got, want := len(bps), 1; got != want {
+		t.Fatalf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := bps[0].exception, panicFilter; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := bps[0].column, 2; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := bps[0].expr, `fmt.Sprintf("bad %d", 42)`; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	bps = exceptionSites(src, true)
+	if got, want := len(bps), 2; got != want {
+		t.Fatalf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := bps[0].exception, recoverFilter; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
+
+func Test_Adapter_exceptionText(t *testing.T) {
+	a := NewAdapter(nil, "", &Options{StringMethodTimeout: 10 * time.Millisecond})
+	block := make(stringerBlocking)
+	defer close(block)
+
+	cases := []struct {
+		name string
+		ex   *exception
+		out  string
+	}{
+		{"error", &exception{value: reflect.ValueOf(errors.New("boom"))}, "boom"},
+		{"string", &exception{value: reflect.ValueOf("bad input")}, "bad input"},
+		{"int", &exception{value: reflect.ValueOf(42)}, "42"},
+		{"nil", &exception{}, "nil"},
+		{"unevaluated", &exception{expr: "f(x)", err: errors.New("function calls are not supported")}, "unknown value of panic(f(x))"},
+		{"timeout", &exception{value: reflect.ValueOf(block)}, "dbg.stringerBlocking"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			if got, want := a.exceptionText(each.ex), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func TestAdapter_panicNotFirstOnLine(t *testing.T) {
+	skipRace(t)
+	src := `package main
+
+func try(err interface{}) {
+	defer func() { recover() }()
+	if err != nil { panic(err) }
+}
+
+func main() {
+	try(nil)
+	try("boom")
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.start()
+	if r := c.request(&dap.SetExceptionBreakpointsArguments{Filters: []string{panicFilter}}); !r.Success {
+		t.Fatalf("setExceptionBreakpoints failed: %s", r.Message.GetOr(""))
+	}
+	c.run()
+
+	// the routine only stops once panic is called
+	stop := c.stopped()
+	if got, want := stop.Reason, "exception"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := stop.Text.GetOr(""), "boom"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_exceptionInfo_call(t *testing.T) {
+	src := `package main
+
+import "fmt"
+
+func msg() string { return "boom" }
+
+func main() {
+	defer func() { recover() }()
+	panic(fmt.Errorf("%s %d", msg(), 42))
+}
+`
+	newInterpreter := func(opts interp.Options) (*interp.Interpreter, error) {
+		i := interp.New(opts)
+		return i, i.Use(stdlib.Symbols)
+	}
+	a := NewEvalAdapter(src, &Options{NewInterpreter: newInterpreter})
+	c := newTestClient(t, a)
+	c.start()
+	c.request(&dap.SetExceptionBreakpointsArguments{Filters: []string{panicFilter}})
+	c.run()
+
+	// the value of the call is the one passed to panic, it is not called again
+	stop := c.stopped()
+	if got, want := stop.Text.GetOr(""), "boom 42"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	r := c.request(&dap.ExceptionInfoArguments{ThreadId: stop.ThreadId.Get()})
+	if !r.Success {
+		t.Fatalf("exceptionInfo failed: %s", r.Message.GetOr(""))
+	}
+	body := r.Body.(*dap.ExceptionInfoResponseBody)
+	if got, want := body.ExceptionId, "*errors.errorString"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := body.Description.GetOr(""), "boom 42"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := body.Details.StackTrace.GetOr(""), "main()\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	// the stack trace text does not register frames
+	a.frames.mu.Lock()
+	n := len(a.frames.values)
+	a.frames.mu.Unlock()
+	if n != 0 {
+		t.Errorf("exceptionInfo registered %d frames", n)
+	}
+
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_uncaughtPanic(t *testing.T) {
+	src := `package main
+
+func main() {
+	var s []int
+	_ = s[1]
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.start()
+	c.request(&dap.SetExceptionBreakpointsArguments{Filters: []string{panicFilter}})
+	c.run()
+
+	// runtime errors are not caught, the client is told so
+	for {
+		body := c.event("output").Body.(*dap.OutputEventBody)
+		if body.Category.GetOr("") != "console" {
+			continue
+		}
+		if got, want := body.Output, "The program was ended by a panic"; !strings.HasPrefix(got, want) {
+			t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+		}
+		break
+	}
+	c.event("terminated")
+	c.disconnect()
+}
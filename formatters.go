@@ -0,0 +1,252 @@
+package dbg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formatter formats the values of a type, for types whose fields or elements
+// do not tell much about the value.
+type Formatter struct {
+	// Type is the type of the values. If it is nil, TypeName is the name of
+	// the type as printed by reflect.Type.String, such as "time.Duration".
+	Type     reflect.Type
+	TypeName string
+
+	// Format returns the text of the value, shown for the value of a variable
+	// and wherever the value is printed inline. If Format is nil, the value is
+	// printed as usual.
+	Format func(v reflect.Value) string
+
+	// Children returns the value whose children are shown instead of those of
+	// the value, or the invalid value to show none. If Children is nil, the
+	// children of the value are shown.
+	Children func(v reflect.Value) reflect.Value
+}
+
+// noChildren is a Formatter.Children hiding the children of the values.
+func noChildren(reflect.Value) reflect.Value {
+	return reflect.Value{}
+}
+
+// defaultFormatters are the formatters of common standard library types.
+var defaultFormatters = newFormatters([]Formatter{
+	{
+		Type: reflect.TypeOf(time.Time{}),
+		Format: func(v reflect.Value) string {
+			return "time.Time " + v.Interface().(time.Time).String()
+		},
+	},
+	{
+		Type: reflect.TypeOf(time.Duration(0)),
+		Format: func(v reflect.Value) string {
+			return "time.Duration " + v.Interface().(time.Duration).String()
+		},
+	},
+	{
+		Type: reflect.TypeOf(time.Location{}),
+		Format: func(v reflect.Value) string {
+			l := v.Interface().(time.Location)
+			return "time.Location " + l.String()
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(big.Int{}),
+		Format: func(v reflect.Value) string {
+			x := v.Interface().(big.Int)
+			return "big.Int " + x.String()
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(big.Float{}),
+		Format: func(v reflect.Value) string {
+			x := v.Interface().(big.Float)
+			return "big.Float " + x.String()
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(big.Rat{}),
+		Format: func(v reflect.Value) string {
+			x := v.Interface().(big.Rat)
+			return "big.Rat " + x.String()
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(json.RawMessage{}),
+		Format: func(v reflect.Value) string {
+			return "json.RawMessage " + string(v.Bytes())
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(net.IP{}),
+		Format: func(v reflect.Value) string {
+			return "net.IP " + v.Interface().(net.IP).String()
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(net.IPNet{}),
+		Format: func(v reflect.Value) string {
+			n := v.Interface().(net.IPNet)
+			return "net.IPNet " + n.String()
+		},
+	},
+	{
+		Type: reflect.TypeOf(net.HardwareAddr{}),
+		Format: func(v reflect.Value) string {
+			return "net.HardwareAddr " + v.Interface().(net.HardwareAddr).String()
+		},
+		Children: noChildren,
+	},
+	{
+		Type: reflect.TypeOf(url.URL{}),
+		Format: func(v reflect.Value) string {
+			u := v.Interface().(url.URL)
+			return "url.URL " + u.String()
+		},
+	},
+	{
+		Type:   reflect.TypeOf(http.Header{}),
+		Format: formatHeader,
+	},
+	{
+		Type: reflect.TypeOf(regexp.Regexp{}),
+		Format: func(v reflect.Value) string {
+			re := v.Interface().(regexp.Regexp)
+			return "regexp.Regexp " + re.String()
+		},
+		Children: noChildren,
+	},
+})
+
+// formatHeader formats the fields of an http.Header in order.
+func formatHeader(v reflect.Value) string {
+	h := v.Interface().(http.Header)
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("http.Header{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s: %s", k, strings.Join(h[k], ", "))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// formatters holds formatters by type and by type name.
+type formatters struct {
+	types map[reflect.Type]*Formatter
+	names map[string]*Formatter
+}
+
+// newFormatters returns the formatters of the list. A formatter overrides
+// the formatters of the same type earlier in the list.
+func newFormatters(list []Formatter) *formatters {
+	r := &formatters{
+		types: map[reflect.Type]*Formatter{},
+		names: map[string]*Formatter{},
+	}
+	for i := range list {
+		f := &list[i]
+		if f.Type != nil {
+			r.types[f.Type] = f
+		} else {
+			r.names[f.TypeName] = f
+		}
+	}
+	return r
+}
+
+// with returns the formatters of r, overridden by those of the list.
+func (r *formatters) with(list []Formatter) *formatters {
+	if len(list) == 0 {
+		return r
+	}
+	out := newFormatters(list)
+	for t, f := range r.types {
+		if _, ok := out.types[t]; !ok {
+			out.types[t] = f
+		}
+	}
+	for n, f := range r.names {
+		if _, ok := out.names[n]; !ok {
+			out.names[n] = f
+		}
+	}
+	return out
+}
+
+// lookup returns the formatter of the type, or nil.
+func (r *formatters) lookup(t reflect.Type) *Formatter {
+	if r == nil {
+		return nil
+	}
+	if f, ok := r.types[t]; ok {
+		return f
+	}
+	if len(r.names) == 0 {
+		return nil
+	}
+	return r.names[t.String()]
+}
+
+// format returns the text of v formatted by the formatter of its type, if
+// any. Values obtained through unexported fields are formatted if they are
+// addressable, and a formatter that panics is ignored.
+func (r *formatters) format(v reflect.Value) (s string, ok bool) {
+	if !v.IsValid() {
+		return "", false
+	}
+	f := r.lookup(v.Type())
+	if f == nil || f.Format == nil || canBeNil(v.Kind()) && v.IsNil() {
+		return "", false
+	}
+	if v, ok = unrestricted(v); !ok {
+		return "", false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s, ok = "", false
+		}
+	}()
+	return f.Format(v), true
+}
+
+// children returns the value whose children are shown for v.
+func (r *formatters) children(v reflect.Value) (out reflect.Value) {
+	f := r.lookup(v.Type())
+	if f == nil || f.Children == nil {
+		return v
+	}
+	u, ok := unrestricted(v)
+	if !ok {
+		return v
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			out = v
+		}
+	}()
+	return f.Children(u)
+}
@@ -0,0 +1,101 @@
+package dbg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type formattedPoint struct {
+	X, Y int
+}
+
+type namedPoint formattedPoint
+
+func Test_formatters(t *testing.T) {
+	a := NewAdapter(nil, "", &Options{Formatters: []Formatter{
+		{
+			Type: reflect.TypeOf(formattedPoint{}),
+			Format: func(v reflect.Value) string {
+				return fmt.Sprintf("(%d, %d)", v.Field(0).Int(), v.Field(1).Int())
+			},
+		},
+		{
+			TypeName: "dbg.namedPoint",
+			Format: func(v reflect.Value) string {
+				return fmt.Sprintf("named (%d, %d)", v.Field(0).Int(), v.Field(1).Int())
+			},
+		},
+		{
+			TypeName: "struct { N int }",
+			Format: func(v reflect.Value) string {
+				panic("formatter")
+			},
+		},
+		{
+			Type: reflect.TypeOf(time.Duration(0)),
+			Format: func(v reflect.Value) string {
+				return "overridden"
+			},
+		},
+	}})
+
+	cases := []struct {
+		name  string
+		value reflect.Value
+		out   string
+	}{
+		{"*big.Int", reflect.ValueOf(big.NewInt(-12)), "*big.Int -12"},
+		{"raw message", reflect.ValueOf(json.RawMessage(`{"a":1}`)), `json.RawMessage {"a":1}`},
+		{"ip", reflect.ValueOf(net.IPv4(127, 0, 0, 1)), "net.IP 127.0.0.1"},
+		{"header", reflect.ValueOf(http.Header{"B": {"2", "3"}, "A": {"1"}}), "http.Header{A: 1, B: 2, 3}"},
+		{"nil header", reflect.ValueOf(http.Header(nil)), "http.Header{}"},
+		{"type", reflect.ValueOf(formattedPoint{1, 2}), "(1, 2)"},
+		{"type name", reflect.ValueOf(&namedPoint{3, 4}), "*named (3, 4)"},
+		{"inline", reflect.ValueOf([]formattedPoint{{5, 6}}), "[]dbg.formattedPoint{(5, 6)}"},
+		{"panic", reflect.ValueOf(struct{ N int }{1}), "struct { N int }{N:1}"},
+		{"override", reflect.ValueOf(time.Second), "overridden"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			if got, want := a.newValuePrinter(64).printString(each.value), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+
+	if d := newValuePrinter(64).printString(reflect.ValueOf(time.Second)); d != "time.Duration 1s" {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", d, "time.Duration 1s")
+	}
+}
+
+func Test_formatters_children(t *testing.T) {
+	a := NewAdapter(nil, "", nil)
+	if v := a.newVar(0, "x", reflect.ValueOf(*big.NewInt(1)), valueFormat{}); v.VariablesReference != 0 {
+		t.Errorf("big.Int has children: %d", v.VariablesReference)
+	}
+
+	a.formatters = defaultFormatters.with([]Formatter{{
+		Type: reflect.TypeOf(formattedPoint{}),
+		Children: func(v reflect.Value) reflect.Value {
+			return reflect.ValueOf([]int64{v.Field(0).Int(), v.Field(1).Int()})
+		},
+	}})
+	v := a.newVar(0, "p", reflect.ValueOf(formattedPoint{1, 2}), valueFormat{})
+	scope, _, ok := a.vars.Get(v.VariablesReference)
+	if !ok {
+		t.Fatal("no children")
+	}
+	vars := scope.Variables(a, 0, page{}, valueFormat{})
+	if got, want := len(vars), 2; got != want {
+		t.Fatalf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got, want := vars[1].Value, "2"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+package dbg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// methodSource returns the declarations of the main package files that the
+// String and Error methods may use, and the function literals calling these
+// methods, one for each receiver type. main and init are left out, and
+// package variables are declared without their value unless it is a literal,
+// so that running the declarations runs no code of the program.
+func methodSource(files [][]byte) (string, []string) {
+	var b strings.Builder
+	var calls []string
+	imports := map[string]bool{}
+	b.WriteString("package main\n")
+
+	for _, src := range files {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "", src, 0)
+		if err != nil || f.Name.Name != "main" {
+			continue
+		}
+		text := func(n ast.Node) string {
+			return string(src[fset.Position(n.Pos()).Offset:fset.Position(n.End()).Offset])
+		}
+
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				switch d.Tok {
+				case token.IMPORT:
+					for _, spec := range d.Specs {
+						if s := text(spec); !imports[s] {
+							imports[s] = true
+							b.WriteString("\nimport " + s + "\n")
+						}
+					}
+				case token.TYPE, token.CONST:
+					b.WriteString("\n" + text(d) + "\n")
+				case token.VAR:
+					for _, spec := range d.Specs {
+						if s, ok := varSource(spec.(*ast.ValueSpec), text); ok {
+							b.WriteString("\nvar " + s + "\n")
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv == nil && (d.Name.Name == "main" || d.Name.Name == "init") {
+					continue
+				}
+				b.WriteString("\n" + text(d) + "\n")
+				if recv, ok := stringMethodReceiver(d); ok {
+					calls = append(calls, "(func(v "+recv+") string { return v."+d.Name.Name+"() })")
+					if !strings.HasPrefix(recv, "*") {
+						// the method is also one of the pointer type
+						calls = append(calls, "(func(v *"+recv+") string { return v."+d.Name.Name+"() })")
+					}
+				}
+			}
+		}
+	}
+	return b.String(), calls
+}
+
+// varSource returns the declaration of package variables without running
+// code: their type, or their value if it is a literal.
+func varSource(s *ast.ValueSpec, text func(ast.Node) string) (string, bool) {
+	names := make([]string, len(s.Names))
+	for i, name := range s.Names {
+		names[i] = name.Name
+	}
+	if s.Type != nil {
+		return strings.Join(names, ", ") + " " + text(s.Type), true
+	}
+	for _, v := range s.Values {
+		if _, ok := v.(*ast.BasicLit); !ok {
+			return "", false
+		}
+	}
+	return text(s), true
+}
+
+// stringMethodReceiver returns the receiver type of a String or Error method,
+// T or *T. Methods of generic types are not returned.
+func stringMethodReceiver(d *ast.FuncDecl) (string, bool) {
+	if d.Recv == nil || len(d.Recv.List) != 1 || d.Name.Name != "String" && d.Name.Name != "Error" {
+		return "", false
+	}
+	if d.Type.Params.NumFields() != 0 || d.Type.Results.NumFields() != 1 {
+		return "", false
+	}
+	if res, ok := d.Type.Results.List[0].Type.(*ast.Ident); !ok || res.Name != "string" {
+		return "", false
+	}
+
+	switch t := d.Recv.List[0].Type.(type) {
+	case *ast.Ident:
+		return t.Name, true
+	case *ast.StarExpr:
+		if id, ok := t.X.(*ast.Ident); ok {
+			return "*" + id.Name, true
+		}
+	}
+	return "", false
+}
+
+// interpretedMethods returns the String and Error methods declared by the
+// interpreted program, as functions taking the receiver, by receiver type.
+// The interpreter of the program runs its code under the debugger, which
+// would stop the routine calling them, or wait on a stopped routine. They
+// are instead compiled by another interpreter from methodSource, so that
+// they run without the debugger. They see the package variables of that
+// interpreter, not the ones of the program: no methods are found if the
+// declarations do not compile without the values of the variables.
+//
+// The methods are found by the reflect type of the receiver, which the
+// interpreter only makes distinct for struct types: the methods of other
+// types, and of struct types with the same fields, are not found. The
+// methods are kept until the program restarts.
+func (a *Adapter) interpretedMethods() map[reflect.Type]reflect.Value {
+	if a.methods != nil {
+		return a.methods
+	}
+	a.methods = map[reflect.Type]reflect.Value{}
+
+	var files [][]byte
+	for _, src := range a.compiledSources() {
+		files = append(files, src.text)
+	}
+	src, calls := methodSource(files)
+	if len(calls) == 0 {
+		return a.methods
+	}
+	i, err := a.newInterpreter()
+	if err != nil {
+		return a.methods
+	}
+	if _, err := i.Eval(src); err != nil {
+		return a.methods
+	}
+
+	ambiguous := map[reflect.Type]bool{}
+	for _, call := range calls {
+		fn, err := i.Eval(call)
+		if err != nil || fn.Kind() != rFunc {
+			continue
+		}
+		t := fn.Type().In(0)
+		if t.Kind() == rPtr && t.Elem().Kind() == rStruct || t.Kind() == rStruct {
+			if _, ok := a.methods[t]; ok {
+				ambiguous[t] = true
+			}
+			a.methods[t] = fn
+		}
+	}
+	for t := range ambiguous {
+		delete(a.methods, t)
+	}
+	return a.methods
+}
+
+// callInterpreted returns the result of the interpreted String or Error
+// method fn called on v.
+func callInterpreted(fn, v reflect.Value) (s string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s, ok = "", false
+		}
+	}()
+	return fn.Call([]reflect.Value{v})[0].String(), true
+}
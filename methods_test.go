@@ -0,0 +1,142 @@
+package dbg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+func Test_methodSource(t *testing.T) {
+	src := []byte(`package main
+
+import "fmt"
+
+var (
+	n     = 1
+	db    = open()
+	s, ok string
+)
+
+type E struct{ s string }
+
+func (e E) Error() string { return e.s }
+
+type P struct{}
+
+func (p *P) String() string { return fmt.Sprint(n) }
+
+func (p *P) Name() string { return "" }
+
+func open() int { return 0 }
+
+func init() { n++ }
+
+func main() {}
+`)
+	out, calls := methodSource([][]byte{src, []byte("package other\n\nfunc f() {}\n")})
+	want := `package main
+
+import "fmt"
+
+var n     = 1
+
+var s, ok string
+
+type E struct{ s string }
+
+func (e E) Error() string { return e.s }
+
+type P struct{}
+
+func (p *P) String() string { return fmt.Sprint(n) }
+
+func (p *P) Name() string { return "" }
+
+func open() int { return 0 }
+`
+	if out != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", out, want)
+	}
+	wantCalls := "(func(v E) string { return v.Error() }) (func(v *E) string { return v.Error() }) (func(v *P) string { return v.String() })"
+	if got := strings.Join(calls, " "); got != wantCalls {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, wantCalls)
+	}
+}
+
+func TestAdapter_stringMethod_interpreted(t *testing.T) {
+	src := `package main
+
+import "fmt"
+
+var prefix = "g:"
+
+type E struct{ msg string }
+
+func (e E) Error() string { return "E " + e.msg }
+
+type P struct{ n int }
+
+func (p *P) String() string { return fmt.Sprint("P ", p.n) }
+
+type G struct{ name string }
+
+func (g G) String() string { return prefix + g.name }
+
+type B struct{ b []byte }
+
+func (b B) String() string { panic("broken") }
+
+// S and T have the same fields
+type S struct{ s string }
+
+func (s S) String() string { return "S" }
+
+type T struct{ s string }
+
+func (t T) String() string { return "T" }
+
+func main() {
+	prefix = "changed:"
+	var err error = E{"boom"}
+	p, g, b, s := P{3}, G{"x"}, B{[]byte("y")}, S{"z"}
+	_, _, _, _, _ = err, p, g, b, s
+	println()
+}
+`
+	newInterpreter := func(opts interp.Options) (*interp.Interpreter, error) {
+		i := interp.New(opts)
+		return i, i.Use(stdlib.Symbols)
+	}
+	c := newTestClient(t, NewEvalAdapter(src, &Options{NewInterpreter: newInterpreter, CallStringMethods: true}))
+	c.launch(&dap.SourceBreakpoint{Line: 37})
+	stop := c.stopped()
+	trace := c.request(&dap.StackTraceArguments{ThreadId: stop.ThreadId.Get()}).Body.(*dap.StackTraceResponseBody)
+	scopes := c.request(&dap.ScopesArguments{FrameId: trace.StackFrames[0].Id}).Body.(*dap.ScopesResponseBody)
+	vars := c.request(&dap.VariablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}).Body.(*dap.VariablesResponseBody)
+	got := map[string]string{}
+	for _, v := range vars.Variables {
+		got[v.Name] = v.Value
+	}
+
+	// methods see the package variables as declared, broken ones and the
+	// ones of types with the same fields fall back to the value
+	want := map[string]string{
+		"err": "E boom",
+		"p":   "P 3",
+		"g":   "g:x",
+		"b":   `struct { Xb []uint8 }{Xb:[]uint8{121}}`,
+		"s":   `struct { Xs string }{Xs:"z"}`,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got [%[2]v:%[2]T] want [%[3]v:%[3]T]", name, got[name], value)
+		}
+	}
+
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}
@@ -0,0 +1,100 @@
+package dbg
+
+import (
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+const interpretedOrigin = "interpreted"
+
+// modules returns the packages of the program, sorted by path: the packages
+// compiled from source, and the precompiled packages of Options.Symbols.
+func (a *Adapter) modules() []*dap.Module {
+	if a.interp == nil {
+		return nil
+	}
+
+	// directories of the compiled files, by package name
+	srcs := a.compiledSources()
+	dirs := map[string]string{}
+	for _, src := range srcs {
+		f, err := parser.ParseFile(token.NewFileSet(), src.path, src.text, parser.PackageClauseOnly)
+		if err == nil {
+			dirs[filepath.Dir(src.path)] = f.Name.Name
+		}
+	}
+
+	var mods []*dap.Module
+	for _, src := range srcs {
+		for _, importPath := range fileImports(src.text) {
+			if dir, ok := packageDir(dirs, importPath); ok {
+				delete(dirs, dir)
+				mods = append(mods, newModule(importPath, interpretedOrigin, dir))
+			}
+		}
+	}
+
+	// the program, and the packages that are not imported by name
+	for dir, name := range dirs {
+		mods = append(mods, newModule(name, interpretedOrigin, dir))
+	}
+
+	for importPath, origin := range a.symbolsOrigins() {
+		mods = append(mods, newModule(importPath, origin, ""))
+	}
+
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Name < mods[j].Name })
+	return mods
+}
+
+// packageDir returns the directory of the interpreted package with the given
+// import path.
+func packageDir(dirs map[string]string, importPath string) (string, bool) {
+	for dir := range dirs {
+		d := filepath.ToSlash(dir)
+		if d == importPath || strings.HasSuffix(d, "/"+importPath) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// symbolsOrigins returns the origin of the precompiled packages by import
+// path. Symbols used last replace the previous ones.
+func (a *Adapter) symbolsOrigins() map[string]string {
+	origins := map[string]string{}
+	for _, s := range a.opts.Symbols {
+		origin := s.Origin
+		if origin == "" {
+			origin = "precompiled"
+		}
+		// precompiled symbols are keyed by import path and package name
+		for key := range s.Exports {
+			importPath := key
+			if strings.Contains(key, "/") {
+				importPath = path.Dir(key)
+			}
+			origins[importPath] = origin
+		}
+	}
+	return origins
+}
+
+func newModule(importPath, origin, dir string) *dap.Module {
+	m := &dap.Module{
+		Id:         importPath,
+		Name:       importPath,
+		IsUserCode: dap.Bool(origin == interpretedOrigin),
+		Origin:     dap.Str(origin),
+	}
+	if dir != "" {
+		m.Path = dap.Str(dir)
+	}
+	return m
+}
@@ -0,0 +1,68 @@
+package dbg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+func Test_packageDir(t *testing.T) {
+	dirs := map[string]string{"/go/src/example.com/app": "main", "/go/src/example.com/app/util": "util"}
+	cases := []struct {
+		importPath string
+		out        string
+	}{
+		{"example.com/app/util", "/go/src/example.com/app/util"},
+		{"app/util", "/go/src/example.com/app/util"},
+		{"pp/util", ""},
+		{"fmt", ""},
+	}
+	for _, each := range cases {
+		t.Run(each.importPath, func(t *testing.T) {
+			got, _ := packageDir(dirs, each.importPath)
+			if want := each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func Test_Adapter_symbolsOrigins(t *testing.T) {
+	a := NewAdapter(nil, "", &Options{Symbols: []Symbols{
+		{Origin: "stdlib", Exports: interp.Exports{"os/os": nil, "fmt/fmt": nil}},
+		{Origin: "unrestricted", Exports: interp.Exports{"os/os": nil}},
+		{Exports: interp.Exports{"example.com/x/y": nil}},
+	}})
+	want := map[string]string{"fmt": "stdlib", "os": "unrestricted", "example.com/x": "precompiled"}
+	if got := a.symbolsOrigins(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
+
+func TestAdapter_modules(t *testing.T) {
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Print()\n}\n"
+	a := NewEvalAdapter(src, &Options{Symbols: []Symbols{{Origin: "stdlib", Exports: stdlib.Symbols}}})
+	c := newTestClient(t, a)
+	c.start()
+
+	r := c.request(&dap.ModulesArguments{})
+	if !r.Success {
+		t.Fatalf("modules failed: %s", r.Message.GetOr(""))
+	}
+	origins := map[string]string{}
+	for _, m := range r.Body.(*dap.ModulesResponseBody).Modules {
+		origins[m.Name] = m.Origin.GetOr("")
+	}
+	for name, want := range map[string]string{"main": interpretedOrigin, "fmt": "stdlib"} {
+		if got := origins[name]; got != want {
+			t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+		}
+	}
+
+	c.run()
+	c.event("terminated")
+	c.disconnect()
+}
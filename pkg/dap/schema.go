@@ -23,6 +23,7 @@ type Request struct {
 
 type AttachRequestArguments struct {
 	Restart interface{} `json:"__restart,omitempty"`
+	StopAll *Boolean    `json:"stopAll,omitempty"`
 }
 
 type String string
@@ -492,6 +493,7 @@ type InvalidatedEventBody struct {
 type LaunchRequestArguments struct {
 	NoDebug *Boolean    `json:"noDebug,omitempty"`
 	Restart interface{} `json:"__restart,omitempty"`
+	StopAll *Boolean    `json:"stopAll,omitempty"`
 }
 
 type LoadedSourceEventBodyReason string
@@ -518,6 +520,7 @@ type Module struct {
 	IsOptimized    *Boolean    `json:"isOptimized,omitempty"`
 	IsUserCode     *Boolean    `json:"isUserCode,omitempty"`
 	Name           string      `json:"name"`
+	Origin         *String     `json:"origin,omitempty"`
 	Path           *String     `json:"path,omitempty"`
 	SymbolFilePath *String     `json:"symbolFilePath,omitempty"`
 	SymbolStatus   *String     `json:"symbolStatus,omitempty"`
@@ -0,0 +1,5 @@
+//go:build race
+
+package dbg
+
+const raceEnabled = true
@@ -0,0 +1,211 @@
+package dbg
+
+import (
+	"bytes"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+)
+
+// programSourceReference identifies the source of a program compiled from a
+// string, whose path may not exist on the client machine.
+const programSourceReference = 1
+
+// source is a file compiled by the interpreter.
+type source struct {
+	path string // path used by the client, see sourcePath
+	text []byte
+}
+
+// sourcePath returns the path identifying a file in breakpoint requests.
+// Files are identified by their absolute path, except for SrcPath which is
+// kept as is.
+func (a *Adapter) sourcePath(path string) string {
+	if path == a.opts.SrcPath {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if a.opts.SrcPath != "" {
+		if src, err := filepath.Abs(a.opts.SrcPath); err == nil && src == abs {
+			return a.opts.SrcPath
+		}
+	}
+	return abs
+}
+
+// requestSource returns the path identifying the source of a request, or an
+// empty string if it is missing.
+func (a *Adapter) requestSource(src *dap.Source) string {
+	switch {
+	case a.src != "" && src.SourceReference.Eq(programSourceReference):
+		return a.programPath()
+	case src.Path != nil && src.Path.Get() != "":
+		return a.sourcePath(src.Path.Get())
+	default:
+		return ""
+	}
+}
+
+// programPath returns the path identifying the program compiled from a
+// string: SrcPath, or the name given by the interpreter.
+func (a *Adapter) programPath() string {
+	if a.opts.SrcPath != "" {
+		return a.opts.SrcPath
+	}
+	return interp.DefaultSourceName
+}
+
+// sourceText returns the text of a source compiled by the interpreter, or nil
+// if it was not compiled.
+func (a *Adapter) sourceText(source string) []byte {
+	for _, src := range a.compiledSources() {
+		if src.path == source {
+			return src.text
+		}
+	}
+	return nil
+}
+
+// compiledSources returns the files compiled by the interpreter, sorted by
+// path. A program compiled from a string is returned as programPath. Files
+// are read once per program.
+func (a *Adapter) compiledSources() []*source {
+	if a.interp == nil {
+		return nil
+	}
+
+	var srcs []*source
+	seen := map[string]bool{}
+	if a.src != "" {
+		srcs = append(srcs, &source{path: a.programPath(), text: []byte(a.src)})
+		seen[a.programPath()] = true
+	}
+
+	a.interp.FileSet().Iterate(func(f *token.File) bool {
+		b, ok := a.loaded.Text(f.Name())
+		if !ok {
+			// b is nil if it is not a file, e.g. a program compiled from a
+			// string
+			b, _ = os.ReadFile(f.Name())
+			a.loaded.SetText(f.Name(), b)
+		}
+		if b == nil {
+			return true
+		}
+
+		path := a.sourcePath(f.Name())
+		if !seen[path] {
+			seen[path] = true
+			srcs = append(srcs, &source{path: path, text: b})
+		}
+		return true
+	})
+
+	sort.Slice(srcs, func(i, j int) bool { return srcs[i].path < srcs[j].path })
+	return srcs
+}
+
+// loadedSources holds the texts of the files compiled for the program, and
+// the sources reported to the client.
+type loadedSources struct {
+	mu    *sync.Mutex
+	texts map[string][]byte // by file name, nil if not a file
+	sent  map[string][]byte // by path
+}
+
+func newLoadedSources() *loadedSources {
+	l := new(loadedSources)
+	l.mu = new(sync.Mutex)
+	l.texts = map[string][]byte{}
+	l.sent = map[string][]byte{}
+	return l
+}
+
+// Text returns the text of the named file, if it was read for the program.
+func (l *loadedSources) Text(name string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.texts[name]
+	return b, ok
+}
+
+func (l *loadedSources) SetText(name string, b []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.texts[name] = b
+}
+
+// Reset forgets the texts of the files, which are read again for a new
+// program. The sources reported to the client are kept.
+func (l *loadedSources) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.texts = map[string][]byte{}
+}
+
+// Update records the sources, and returns the reason to report each one that
+// was not reported yet or whose text changed.
+func (l *loadedSources) Update(srcs []*source) map[*source]dap.LoadedSourceEventBodyReason {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reasons := map[*source]dap.LoadedSourceEventBodyReason{}
+	for _, src := range srcs {
+		text, ok := l.sent[src.path]
+		switch {
+		case !ok:
+			reasons[src] = dap.LoadedSourceEventBodyReason_New
+		case !bytes.Equal(text, src.text):
+			reasons[src] = dap.LoadedSourceEventBodyReason_Changed
+		default:
+			continue
+		}
+		l.sent[src.path] = src.text
+	}
+	return reasons
+}
+
+// sendLoadedSources sends a loadedSource event for each source compiled for
+// the program that is new or changed since the last call. It is called once
+// the program is compiled.
+func (a *Adapter) sendLoadedSources() {
+	srcs := a.compiledSources()
+	reasons := a.loaded.Update(srcs)
+	for _, src := range srcs {
+		reason, ok := reasons[src]
+		if !ok {
+			continue
+		}
+		err := a.session.Event("loadedSource", &dap.LoadedSourceEventBody{
+			Reason: reason,
+			Source: *a.dapSource(src.path),
+		})
+		if a.opts.Errors != nil && err != nil {
+			a.opts.Errors <- err
+		}
+	}
+}
+
+// dapSource returns the source at path for the client. The program compiled
+// from a string refers to the text that was compiled, served by the source
+// request, and only has a path if SrcPath is set.
+func (a *Adapter) dapSource(path string) *dap.Source {
+	src := &dap.Source{Name: dap.Str(filepath.Base(path))}
+	if a.src != "" && path == a.programPath() {
+		src.SourceReference = dap.Int(programSourceReference)
+		if a.opts.SrcPath == "" {
+			return src
+		}
+	}
+	src.Path = dap.Str(path)
+	return src
+}
@@ -0,0 +1,91 @@
+package dbg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+func Test_loadedSources_Update(t *testing.T) {
+	l := newLoadedSources()
+	a, b := &source{path: "/a.go", text: []byte("a")}, &source{path: "/b.go", text: []byte("b")}
+
+	got := l.Update([]*source{a})
+	if want := map[*source]dap.LoadedSourceEventBodyReason{a: "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	got = l.Update([]*source{a, b})
+	if want := map[*source]dap.LoadedSourceEventBodyReason{b: "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	if got := l.Update([]*source{a, b}); len(got) != 0 {
+		t.Errorf("got [%[1]v:%[1]T] want none", got)
+	}
+
+	c := &source{path: "/a.go", text: []byte("c")}
+	got = l.Update([]*source{c, b})
+	if want := map[*source]dap.LoadedSourceEventBodyReason{c: "changed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
+
+func TestAdapter_loadedSource(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	reload := func() (string, error) {
+		return strings.Replace(src, "1", "2", 1), nil
+	}
+
+	c := newTestClient(t, NewEvalAdapter(src, &Options{Reload: reload}))
+	c.launch(&dap.SourceBreakpoint{Line: 5})
+	if got, want := c.event("loadedSource").Body.(*dap.LoadedSourceEventBody).Reason, dap.LoadedSourceEventBodyReason_New; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	c.stopped()
+
+	if r := c.request(&dap.RestartArguments{}); !r.Success {
+		t.Fatalf("restart failed: %s", r.Message.GetOr(""))
+	}
+	if got, want := c.event("loadedSource").Body.(*dap.LoadedSourceEventBody).Reason, dap.LoadedSourceEventBodyReason_Changed; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	stop := c.stopped()
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+	for _, e := range c.events {
+		if e.Event == "loadedSource" {
+			t.Errorf("unexpected loadedSource event %v", e.Body)
+		}
+	}
+}
+
+func Test_Adapter_dapSource(t *testing.T) {
+	cases := []struct {
+		name    string
+		src     string
+		srcPath string
+		path    string
+		outPath string
+		outRef  int
+	}{
+		{"file", "", "", "/x/a.go", "/x/a.go", 0},
+		{"string with path", "package main", "/x/main.go", "/x/main.go", "/x/main.go", programSourceReference},
+		{"string without path", "package main", "", "_.go", "", programSourceReference},
+		{"other file", "package main", "/x/main.go", "/x/a.go", "/x/a.go", 0},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			a := NewEvalAdapter(each.src, &Options{SrcPath: each.srcPath})
+			src := a.dapSource(each.path)
+			if got, want := src.Path.GetOr(""), each.outPath; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+			if got, want := src.SourceReference.GetOr(0), each.outRef; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
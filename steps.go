@@ -0,0 +1,274 @@
+package dbg
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+	"github.com/traefik/yaegi/interp"
+)
+
+// builtins are the predeclared functions, which cannot be stepped into.
+var builtins = map[string]bool{
+	"append": true, "cap": true, "clear": true, "close": true, "complex": true,
+	"copy": true, "delete": true, "imag": true, "len": true, "make": true,
+	"max": true, "min": true, "new": true, "panic": true, "print": true,
+	"println": true, "real": true, "recover": true,
+}
+
+// span is the offsets of a node in a source.
+type span struct {
+	pos, end int
+}
+
+// call is a function call on a line of source.
+type call struct {
+	span
+	name  string // name of the function or method
+	label string // source of the call expression
+}
+
+// lineCalls returns the calls on the line of src, in the order they are
+// evaluated: arguments before the call using them.
+func lineCalls(src []byte, line int) []*call {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil
+	}
+
+	var calls []*call
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		start, end := fset.Position(n.Pos()).Line, fset.Position(n.End()).Line
+		if start > line || end < line {
+			return false
+		}
+		switch n.(type) {
+		case *ast.GoStmt, *ast.DeferStmt:
+			// the call is not made by this routine, or not now
+			return false
+		case *ast.FuncLit:
+			if start != line {
+				// the body of a closure is not run by this line
+				return false
+			}
+		}
+
+		x, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		// arguments are evaluated before the call
+		for _, arg := range append([]ast.Expr{x.Fun}, x.Args...) {
+			ast.Inspect(arg, visit)
+		}
+		if start != line {
+			return false
+		}
+
+		var name string
+		switch fun := x.Fun.(type) {
+		case *ast.Ident:
+			name = fun.Name
+			if builtins[name] {
+				return false
+			}
+		case *ast.SelectorExpr:
+			name = fun.Sel.Name
+		default:
+			return false
+		}
+		pos, end := fset.Position(x.Pos()).Offset, fset.Position(x.End()).Offset
+		calls = append(calls, &call{span: span{pos, end}, name: name, label: string(src[pos:end])})
+		return false
+	}
+	ast.Inspect(f, visit)
+	return calls
+}
+
+// isStopNode reports whether the interpreter may stop at the node. Names,
+// literals and types are not run, and neither are statements whose parts are
+// run instead, such as an expression statement or an if statement.
+func isStopNode(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.AssignStmt, *ast.IncDecStmt, *ast.SendStmt, *ast.DeclStmt:
+		return true
+	case *ast.Ident, *ast.BasicLit, *ast.ParenExpr, *ast.KeyValueExpr, *ast.Ellipsis,
+		*ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.StructType, *ast.InterfaceType:
+		return false
+	}
+	_, ok := n.(ast.Expr)
+	return ok
+}
+
+// stopNode returns the node of src that a routine stopped at last on the line
+// of the trail. Nodes starting at the same column are told apart by the
+// previous stops: the interpreter runs the nodes of a line in post-order, so
+// the node must come after the previous one. The first stop of a breakpoint
+// is at the outermost node, the one marked by the interpreter. A stop at no
+// known node is returned as an empty span.
+func stopNode(src []byte, t *lineTrail) span {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return span{}
+	}
+	file := fset.File(f.Pos())
+	if t.line < 1 || t.line > file.LineCount() {
+		return span{}
+	}
+	start := file.Offset(file.LineStart(t.line))
+
+	// nodes of the line by offset, outermost first
+	nodes := map[int][]span{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || fset.Position(n.Pos()).Line > t.line || fset.Position(n.End()).Line < t.line {
+			return false
+		}
+		if isStopNode(n) && fset.Position(n.Pos()).Line == t.line {
+			pos := fset.Position(n.Pos()).Offset
+			nodes[pos] = append(nodes[pos], span{pos, fset.Position(n.End()).Offset})
+		}
+		return true
+	})
+
+	var n span
+	for i, col := range t.cols {
+		prev := n
+		pos := start + col - 1
+		chain := nodes[pos]
+		n = span{pos, pos}
+		switch {
+		case len(chain) == 0:
+		case i == 0 && t.brk:
+			n = chain[0]
+		default:
+			n = chain[len(chain)-1]
+			for j := len(chain) - 1; i > 0 && j >= 0; j-- {
+				if c := chain[j]; c != prev && (c.pos <= prev.pos && prev.end <= c.end || c.pos >= prev.end) {
+					n = c
+					break
+				}
+			}
+		}
+	}
+	return n
+}
+
+// frameText returns the source of the frame, as it was compiled.
+func (a *Adapter) frameText(f *interp.DebugFrame) []byte {
+	if prog := f.Program(); prog != nil && prog == a.program && a.src != "" {
+		return []byte(a.src)
+	}
+	return a.sourceText(a.sourcePath(f.Position().Filename))
+}
+
+// reachableCalls returns the calls on the current line of the routine that it
+// has not made yet: the one it is stopped at, and the ones after it. The
+// arguments of a call are made before it.
+func (a *Adapter) reachableCalls(e *interp.DebugEvent) []*call {
+	frames := e.Frames(0, 1)
+	if len(frames) == 0 {
+		return nil
+	}
+	pos := frames[0].Position()
+	t, ok := a.trails.Get(e.GoRoutine(), e.FrameDepth())
+	if !ok || t.line != pos.Line || t.file != pos.Filename {
+		t = &lineTrail{file: pos.Filename, line: pos.Line, cols: []int{pos.Column}}
+	}
+
+	src := a.frameText(frames[0])
+	n := stopNode(src, t)
+	var calls []*call
+	for _, c := range lineCalls(src, pos.Line) {
+		if c.span == n || c.end > n.end {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// stepInTargets returns the calls that the routine can step into from the
+// frame. Their IDs are their index, starting at 1. Only the current frame of
+// the routine has targets.
+func (a *Adapter) stepInTargets(e *interp.DebugEvent, f *interp.DebugFrame) []*dap.StepInTarget {
+	frames := e.Frames(0, 1)
+	if len(frames) == 0 || frames[0].Name() != f.Name() || frames[0].Position() != f.Position() {
+		return []*dap.StepInTarget{}
+	}
+
+	calls := a.reachableCalls(e)
+	targets := make([]*dap.StepInTarget, len(calls))
+	for i, c := range calls {
+		targets[i] = &dap.StepInTarget{Id: i + 1, Label: c.label}
+	}
+	return targets
+}
+
+// setStepInTarget makes the routine step into the call with the given ID on
+// its current line, instead of the first one.
+func (a *Adapter) setStepInTarget(e *interp.DebugEvent, id int) bool {
+	calls := a.reachableCalls(e)
+	if id < 1 || id > len(calls) {
+		return false
+	}
+
+	pos := e.Frames(0, 1)[0].Position()
+	a.stepTargets.Set(e.GoRoutine(), &stepInTarget{
+		name:  calls[id-1].name,
+		file:  pos.Filename,
+		line:  pos.Line,
+		depth: e.FrameDepth(),
+	})
+	return true
+}
+
+// continueStepIn steps the routine further if it is stepping into a call that
+// was not entered yet: other calls are stepped out of, and the line is
+// stepped into again. It reports whether the routine was stepped.
+func (a *Adapter) continueStepIn(e *interp.DebugEvent) bool {
+	id := e.GoRoutine()
+	t, ok := a.stepTargets.Get(id)
+	if !ok {
+		return false
+	}
+
+	frames := e.Frames(0, 1)
+	if len(frames) == 0 {
+		a.stepTargets.Release(id)
+		return false
+	}
+	f := frames[0]
+	pos := f.Position()
+
+	var reason interp.DebugEventReason
+	switch depth := e.FrameDepth(); {
+	case depth > t.depth && isFuncName(f.Name(), t.name):
+		a.stepTargets.Release(id)
+		return false
+	case depth > t.depth:
+		reason = interp.DebugStepOut
+	case depth == t.depth && pos.Line == t.line && pos.Filename == t.file:
+		reason = interp.DebugStepInto
+	default:
+		// the call was not made
+		a.stepTargets.Release(id)
+		return false
+	}
+
+	a.stepFrom(id, reason)
+	return true
+}
+
+// isFuncName reports whether the frame name is the one of the function or
+// method name.
+func isFuncName(frame, name string) bool {
+	return frame == name || strings.HasSuffix(frame, "."+name)
+}
@@ -0,0 +1,204 @@
+package dbg
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+func Test_lineCalls(t *testing.T) {
+	src := []byte(`package main
+
+func main() {
+	x := f(g(1), h.Run(len(s)))
+	go func() { k() }()
+	m(n(),
+		o())
+}
+`)
+	cases := []struct {
+		line int
+		out  string
+	}{
+		{4, "g(1) h.Run(len(s)) f(g(1), h.Run(len(s)))"},
+		{5, ""},
+		{6, "n() m(n(),\n\t\to())"},
+		{7, "o()"},
+		{8, ""},
+	}
+	for _, each := range cases {
+		t.Run(strconv.Itoa(each.line), func(t *testing.T) {
+			var labels []string
+			for _, c := range lineCalls(src, each.line) {
+				labels = append(labels, c.label)
+			}
+			if got, want := strings.Join(labels, " "), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func Test_stopNode(t *testing.T) {
+	src := []byte(`package main
+
+func main() {
+	a := t.Run(g(1))
+	c := g(h(1)) + h(g(2))
+	if g(1) > h(0) {
+	}
+	return f(g(1), h(2))
+}
+`)
+	cases := []struct {
+		line int
+		brk  bool
+		cols []int
+		out  string
+	}{
+		{4, false, []int{7}, "t.Run"},
+		{4, false, []int{7, 13}, "g(1)"},
+		{4, false, []int{7, 13, 7}, "t.Run(g(1))"},
+		{4, true, []int{2}, "a := t.Run(g(1))"},
+		{5, false, []int{9, 7}, "g(h(1))"},
+		{5, false, []int{9, 7, 19, 17, 7}, "g(h(1)) + h(g(2))"},
+		{6, false, []int{5}, "g(1)"},
+		{6, false, []int{5, 12, 5}, "g(1) > h(0)"},
+		{6, true, []int{5}, "g(1) > h(0)"},
+		{8, true, []int{9}, "f(g(1), h(2))"},
+		{8, false, []int{2}, ""},
+	}
+	for _, each := range cases {
+		n := stopNode(src, &lineTrail{line: each.line, brk: each.brk, cols: each.cols})
+		if got, want := string(src[n.pos:n.end]), each.out; got != want {
+			t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+		}
+	}
+}
+
+func TestAdapter_stepInTarget(t *testing.T) {
+	skipRace(t)
+	src := `package main
+
+func f(a, b int) int { return a + b }
+
+func g(i int) int { return i }
+
+func h(i int) int { return i }
+
+func main() {
+	println()
+	r := f(g(1), h(2))
+	println(r)
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.launch(&dap.SourceBreakpoint{Line: 10})
+	stop := c.stopped()
+	id := stop.ThreadId.Get()
+	var trace *dap.StackTraceResponseBody
+	for i := 0; i < 10; i++ {
+		if r := c.request(&dap.NextArguments{ThreadId: id}); !r.Success {
+			t.Fatalf("next failed: %s", r.Message.GetOr(""))
+		}
+		c.stopped()
+		trace = c.request(&dap.StackTraceArguments{ThreadId: id}).Body.(*dap.StackTraceResponseBody)
+		if trace.StackFrames[0].Line != 10 {
+			break
+		}
+	}
+
+	// before the line runs, all its calls are targets
+	if got, want := trace.StackFrames[0].Line, 11; got != want {
+		t.Fatalf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+	r := c.request(&dap.StepInTargetsArguments{FrameId: trace.StackFrames[0].Id})
+	if !r.Success {
+		t.Fatalf("stepInTargets failed: %s", r.Message.GetOr(""))
+	}
+	var labels []string
+	target := 0
+	for _, each := range r.Body.(*dap.StepInTargetsResponseBody).Targets {
+		labels = append(labels, each.Label)
+		if each.Label == "h(2)" {
+			target = each.Id
+		}
+	}
+	if got, want := strings.Join(labels, " "), "g(1) h(2) f(g(1), h(2))"; got != want {
+		t.Fatalf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	// g is stepped over to stop in h
+	if r := c.request(&dap.StepInArguments{ThreadId: id, TargetId: dap.Int(target)}); !r.Success {
+		t.Fatalf("stepIn failed: %s", r.Message.GetOr(""))
+	}
+	c.stopped()
+	trace = c.request(&dap.StackTraceArguments{ThreadId: id}).Body.(*dap.StackTraceResponseBody)
+	if got, want := trace.StackFrames[0].Name, "h"; !isFuncName(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+
+	c.cont(id)
+	c.event("terminated")
+	c.disconnect()
+}
+
+func TestAdapter_stepInTargets_breakpoint(t *testing.T) {
+	src := `package main
+
+func f(a, b int) int { return a + b }
+
+func g(i int) int { return i }
+
+func k() int {
+	return f(g(1), g(2))
+}
+
+func main() {
+	r := f(g(1), g(2))
+	println(r, k())
+}
+`
+	c := newTestClient(t, NewEvalAdapter(src, nil))
+	c.launch(&dap.SourceBreakpoint{Line: 8}, &dap.SourceBreakpoint{Line: 12})
+
+	// the calls of an assignment are made when it is reached, and the
+	// arguments of a returned call
+	for _, want := range []string{"", "f(g(1), g(2))"} {
+		stop := c.stopped()
+		trace := c.request(&dap.StackTraceArguments{ThreadId: stop.ThreadId.Get()}).Body.(*dap.StackTraceResponseBody)
+		r := c.request(&dap.StepInTargetsArguments{FrameId: trace.StackFrames[0].Id})
+		if !r.Success {
+			t.Fatalf("stepInTargets failed: %s", r.Message.GetOr(""))
+		}
+		var labels []string
+		for _, each := range r.Body.(*dap.StepInTargetsResponseBody).Targets {
+			labels = append(labels, each.Label)
+		}
+		if got := strings.Join(labels, " "); got != want {
+			t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+		}
+		c.cont(stop.ThreadId.Get())
+	}
+	c.event("terminated")
+	c.disconnect()
+}
+
+func Test_isFuncName(t *testing.T) {
+	cases := []struct {
+		frame, name string
+		out         bool
+	}{
+		{"f", "f", true},
+		{"main.f", "f", true},
+		{"T.Run", "Run", true},
+		{"main.ff", "f", false},
+	}
+	for _, each := range cases {
+		if got, want := isFuncName(each.frame, each.name), each.out; got != want {
+			t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package dbg
 
 import (
+	"go/token"
+	"reflect"
 	"sync"
 
 	"github.com/traefik/yaegi/interp"
@@ -37,43 +39,347 @@ func (t *events) Release(id int) {
 	delete(t.values, id)
 }
 
+// IDs returns the routines with a retained event.
+func (t *events) IDs() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]int, 0, len(t.values))
+	for id := range t.values {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// frames holds the frames sent to the client. IDs are not reused, so that the
+// frames of a routine can be purged while the other routines are stopped.
 type frames struct {
-	mu     *sync.Mutex
-	values []*interp.DebugFrame
-	id     int
+	mu       *sync.Mutex
+	values   map[int]*interp.DebugFrame
+	routines map[int]int         // routine of each frame
+	formats  map[int]valueFormat // format of the stack trace of each frame
+	id       int
 }
 
 func newFrames() *frames {
 	f := new(frames)
 	f.mu = new(sync.Mutex)
+	f.values = map[int]*interp.DebugFrame{}
+	f.routines = map[int]int{}
+	f.formats = map[int]valueFormat{}
 	return f
 }
 
-func (r *frames) Purge() {
+// Purge removes the frames of the routine.
+func (r *frames) Purge(routine int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.id = 0
-	if r.values != nil {
-		r.values = r.values[:0]
+	for i, id := range r.routines {
+		if id == routine {
+			delete(r.values, i)
+			delete(r.routines, i)
+			delete(r.formats, i)
+		}
 	}
 }
 
-func (r *frames) Add(v *interp.DebugFrame) int {
+func (r *frames) Add(routine int, v *interp.DebugFrame, f valueFormat) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	r.id++
-	r.values = append(r.values, v)
+	r.values[r.id] = v
+	r.routines[r.id] = routine
+	r.formats[r.id] = f
 	return r.id
 }
 
-func (r *frames) Get(i int) (*interp.DebugFrame, bool) {
+// Get returns the frame and its routine.
+func (r *frames) Get(i int) (*interp.DebugFrame, int, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if i < 1 || i > len(r.values) {
+	f, ok := r.values[i]
+	return f, r.routines[i], ok
+}
+
+// Format returns the format of the stack trace of the frame, used for its
+// variables when the client does not request one.
+func (r *frames) Format(i int) valueFormat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.formats[i]
+}
+
+// exception is a panic raised by a routine.
+type exception struct {
+	expr      string // argument of the call to panic
+	value     reflect.Value
+	err       error // set if the panic value cannot be evaluated
+	recovered bool
+}
+
+type exceptions struct {
+	mu     *sync.Mutex
+	values map[int]*exception
+}
+
+func newExceptions() *exceptions {
+	e := new(exceptions)
+	e.mu = new(sync.Mutex)
+	e.values = map[int]*exception{}
+	return e
+}
+
+func (t *exceptions) Get(id int) (*exception, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.values[id]
+	return e, ok
+}
+
+func (t *exceptions) Set(id int, e *exception) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[id] = e
+}
+
+// Recover marks the panic of the routine as recovered, and reports whether
+// the routine was panicking.
+func (t *exceptions) Recover(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.values[id]
+	if !ok || e.recovered {
+		return false
+	}
+	e.recovered = true
+	return true
+}
+
+func (t *exceptions) Release(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, id)
+}
+
+// ReleaseRecovered releases the exception of the routine if it has been
+// recovered.
+func (t *exceptions) ReleaseRecovered(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.values[id]; ok && e.recovered {
+		delete(t.values, id)
+	}
+}
+
+// panicStep is the line of a routine stepped over to reach its calls to
+// panic, which are not where the line breaks.
+type panicStep struct {
+	file  string
+	line  int
+	depth int
+	sites []*breakpoint
+}
+
+type panicSteps struct {
+	mu     *sync.Mutex
+	values map[int]*panicStep
+}
+
+func newPanicSteps() *panicSteps {
+	p := new(panicSteps)
+	p.mu = new(sync.Mutex)
+	p.values = map[int]*panicStep{}
+	return p
+}
+
+func (p *panicSteps) Get(id int) (*panicStep, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.values[id]
+	return s, ok
+}
+
+// Add adds the call to panic to the line of the routine at pos and depth.
+func (p *panicSteps) Add(id, depth int, pos token.Position, bp *breakpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.values[id]
+	if !ok || s.depth != depth || s.line != pos.Line || s.file != pos.Filename {
+		s = &panicStep{file: pos.Filename, line: pos.Line, depth: depth}
+		p.values[id] = s
+	}
+	s.sites = append(s.sites, bp)
+}
+
+func (p *panicSteps) Release(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.values, id)
+}
+
+// stepInTarget is the call a routine is stepping into, from a line of the
+// frame at depth.
+type stepInTarget struct {
+	name  string
+	file  string
+	line  int
+	depth int
+}
+
+type stepInTargets struct {
+	mu     *sync.Mutex
+	values map[int]*stepInTarget
+}
+
+func newStepInTargets() *stepInTargets {
+	t := new(stepInTargets)
+	t.mu = new(sync.Mutex)
+	t.values = map[int]*stepInTarget{}
+	return t
+}
+
+func (t *stepInTargets) Get(id int) (*stepInTarget, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.values[id]
+	return s, ok
+}
+
+func (t *stepInTargets) Set(id int, s *stepInTarget) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[id] = s
+}
+
+func (t *stepInTargets) Release(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, id)
+}
+
+// lineTrail is the columns a routine stopped at on a line of the frame at
+// depth, since it reached the line. It tells apart the nodes of the line that
+// start at the same column, see stopNode.
+type lineTrail struct {
+	file string
+	line int
+	brk  bool // the line was reached at a breakpoint
+	cols []int
+}
+
+// lineTrails holds the trails of each routine, by frame depth.
+type lineTrails struct {
+	mu     *sync.Mutex
+	values map[int]map[int]*lineTrail
+}
+
+func newLineTrails() *lineTrails {
+	t := new(lineTrails)
+	t.mu = new(sync.Mutex)
+	t.values = map[int]map[int]*lineTrail{}
+	return t
+}
+
+// Get returns a copy of the trail of the routine at depth.
+func (t *lineTrails) Get(id, depth int) (*lineTrail, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.values[id][depth]
+	if !ok {
 		return nil, false
 	}
-	return r.values[i-1], true
+	c := *l
+	c.cols = append([]int(nil), l.cols...)
+	return &c, true
+}
+
+// Add adds a stop of the routine at pos and depth. The trails of deeper
+// frames are removed, as they returned. A stop that is not a step starts a
+// new trail, as the routine may have run any part of the line meanwhile.
+func (t *lineTrails) Add(id, depth int, pos token.Position, reason interp.DebugEventReason) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	trails, ok := t.values[id]
+	if !ok {
+		trails = map[int]*lineTrail{}
+		t.values[id] = trails
+	}
+	for d := range trails {
+		if d > depth {
+			delete(trails, d)
+		}
+	}
+
+	l, ok := trails[depth]
+	switch reason {
+	case interp.DebugStepInto, interp.DebugStepOver, interp.DebugStepOut:
+		if ok && l.line == pos.Line && l.file == pos.Filename {
+			l.cols = append(l.cols, pos.Column)
+			return
+		}
+	}
+	trails[depth] = &lineTrail{
+		file: pos.Filename,
+		line: pos.Line,
+		brk:  reason == interp.DebugBreak,
+		cols: []int{pos.Column},
+	}
+}
+
+func (t *lineTrails) Release(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, id)
+}
+
+// interrupts holds the routines interrupted so that all routines stop, until
+// they pause. Routines whose interrupt is cancelled resume once they pause.
+type interrupts struct {
+	mu     *sync.Mutex
+	values map[int]bool
+}
+
+func newInterrupts() *interrupts {
+	t := new(interrupts)
+	t.mu = new(sync.Mutex)
+	t.values = map[int]bool{}
+	return t
+}
+
+func (t *interrupts) Add(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[id] = true
+}
+
+// Take removes the interrupt of the routine, and reports whether it should
+// stop.
+func (t *interrupts) Take(id int) (stop, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stop, ok = t.values[id]
+	delete(t.values, id)
+	return stop, ok
+}
+
+// Release removes the interrupt of a routine that stopped or exited before
+// it paused, so that it does not take a later pause of the routine.
+func (t *interrupts) Release(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, id)
+}
+
+// Cancel cancels the interrupts of the routines that did not pause yet.
+func (t *interrupts) Cancel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id := range t.values {
+		t.values[id] = false
+	}
 }
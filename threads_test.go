@@ -0,0 +1,44 @@
+package dbg
+
+import "testing"
+
+func Test_interrupts(t *testing.T) {
+	r := newInterrupts()
+	r.Add(2)
+	r.Add(3)
+	if stop, ok := r.Take(2); !stop || !ok {
+		t.Errorf("got [%v %v] want [true true]", stop, ok)
+	}
+	if _, ok := r.Take(2); ok {
+		t.Error("interrupt taken twice")
+	}
+
+	r.Cancel()
+	if stop, ok := r.Take(3); stop || !ok {
+		t.Errorf("got [%v %v] want [false true]", stop, ok)
+	}
+
+	// a routine that stopped elsewhere does not take a later pause
+	r.Add(4)
+	r.Release(4)
+	if _, ok := r.Take(4); ok {
+		t.Error("released interrupt taken")
+	}
+}
+
+func Test_variables_Purge(t *testing.T) {
+	r := newVariables()
+	a := r.Add(1, &elemVars{}, valueFormat{})
+	b := r.Add(2, &elemVars{}, valueFormat{})
+
+	r.Purge(1)
+	if _, _, ok := r.Get(a); ok {
+		t.Error("reference of routine 1 not purged")
+	}
+	if _, routine, ok := r.Get(b); !ok || routine != 2 {
+		t.Errorf("got [%v %v] want [2 true]", routine, ok)
+	}
+	if c := r.Add(1, &elemVars{}, valueFormat{}); c == a || c == b {
+		t.Errorf("reference %d reused", c)
+	}
+}
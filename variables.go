@@ -2,11 +2,16 @@ package dbg
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"math/cmplx"
 	"reflect"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
 	"github.com/traefik/yaegi/interp"
@@ -41,48 +46,100 @@ const (
 	rUnsafePointer = reflect.UnsafePointer
 )
 
+const (
+	defaultValueLength = 128
+	replValueLength    = 1024
+	defaultValueDepth  = 5
+
+	// rangeSize is the maximum number of children of a variable, beyond which
+	// they are grouped into range nodes.
+	rangeSize = 1000
+
+	// sortedMapLength is the maximum length of the maps whose entries are
+	// printed in order. Larger maps are printed in the order of the map, as
+	// sorting them costs more than the printed prefix is worth.
+	sortedMapLength = 100
+)
+
+// variables holds the variable references sent to the client. Like frames,
+// references belong to a routine, and are not reused. References to values
+// that do not belong to a routine, such as package variables, belong to
+// routine 0.
 type variables struct {
-	mu     *sync.Mutex
-	values []variableScope
-	id     int
+	mu       *sync.Mutex
+	values   map[int]variableScope
+	routines map[int]int
+	formats  map[int]valueFormat // format of the children, unless requested
+	id       int
 }
 
 func newVariables() *variables {
 	v := new(variables)
 	v.mu = new(sync.Mutex)
+	v.values = map[int]variableScope{}
+	v.routines = map[int]int{}
+	v.formats = map[int]valueFormat{}
 	return v
 }
 
-func (r *variables) Purge() {
+// Purge removes the references of the routine.
+func (r *variables) Purge(routine int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.id = 0
-	if r.values != nil {
-		r.values = r.values[:0]
+	for i, id := range r.routines {
+		if id == routine {
+			delete(r.values, i)
+			delete(r.routines, i)
+			delete(r.formats, i)
+		}
 	}
 }
 
-func (r *variables) Add(v variableScope) int {
+func (r *variables) Add(routine int, v variableScope, f valueFormat) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	r.id++
-	r.values = append(r.values, v)
+	r.values[r.id] = v
+	r.routines[r.id] = routine
+	r.formats[r.id] = f
 	return r.id
 }
 
-func (r *variables) Get(i int) (variableScope, bool) {
+// Get returns the scope of the reference and its routine.
+func (r *variables) Get(i int) (variableScope, int, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if i < 1 || i > len(r.values) {
-		return nil, false
-	}
-	return r.values[i-1], true
+	v, ok := r.values[i]
+	return v, r.routines[i], ok
+}
+
+// Format returns the format of the variable the reference was created for,
+// used for its children when the client does not request one.
+func (r *variables) Format(i int) valueFormat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.formats[i]
+}
+
+// valueFormat is how values are printed.
+type valueFormat struct {
+	hex bool // integers in hexadecimal, strings and bytes as hex dumps
 }
 
-func (a *Adapter) newVar(name string, rv reflect.Value) *dap.Variable {
+func newValueFormat(f *dap.ValueFormat) valueFormat {
+	return valueFormat{hex: f != nil && f.Hex.True()}
+}
+
+// newVar returns a variable of the routine, see variables.
+func (a *Adapter) newVar(routine int, name string, rv reflect.Value, format valueFormat) *dap.Variable {
+	return a.newVarWithMaxLength(routine, name, rv, format, defaultValueLength)
+}
+
+func (a *Adapter) newVarWithMaxLength(routine int, name string, rv reflect.Value, format valueFormat, maxLength int) *dap.Variable {
 	v := new(dap.Variable)
 	v.Name = name
 	v.Type = dap.Str(rv.Type().String())
@@ -93,107 +150,578 @@ func (a *Adapter) newVar(name string, rv reflect.Value) *dap.Variable {
 		return v
 	}
 
-	vp := newValuePrinter(128)
-	vp.print(rv)
+	vp := a.newValuePrinter(maxLength)
+	vp.hex = format.hex
+	if s, ok := a.stringMethod(rv, format); ok {
+		fmt.Fprint(vp, s)
+	} else {
+		vp.print(rv)
+	}
 	v.Value = vp.String()
 
+	if rv = vp.formatters.children(rv); !rv.IsValid() {
+		return v
+	}
 	switch rv.Kind() {
 	case rInterface, rPtr:
-		v.VariablesReference = a.vars.Add(&elemVars{rv})
+		v.VariablesReference = a.vars.Add(routine, &elemVars{rv}, format)
 	case rArray, rSlice:
-		v.VariablesReference = a.vars.Add(&arrayVars{rv})
+		v.VariablesReference = a.vars.Add(routine, &arrayVars{rv}, format)
+		v.IndexedVariables = dap.Int(rv.Len())
 	case rStruct:
-		v.VariablesReference = a.vars.Add(&structVars{rv})
+		v.VariablesReference = a.vars.Add(routine, &structVars{rv}, format)
+		v.NamedVariables = dap.Int(rv.NumField())
 	case rMap:
-		v.VariablesReference = a.vars.Add(&mapVars{rv})
+		v.VariablesReference = a.vars.Add(routine, &mapVars{Value: rv}, format)
+		v.IndexedVariables = dap.Int(rv.Len())
 	}
 
 	return v
 }
 
+// stringMethod returns the result of the Error or String method of rv, or of
+// its address, if the adapter calls them for values without a formatter.
+func (a *Adapter) stringMethod(rv reflect.Value, format valueFormat) (string, bool) {
+	if !a.opts.CallStringMethods || format.hex {
+		return "", false
+	}
+	if f := a.formatters.lookup(rv.Type()); f != nil && f.Format != nil {
+		return "", false
+	}
+	return a.callStringMethod(rv)
+}
+
+// callStringMethod returns the result of the Error or String method of rv, or
+// of its address. The method is called on another routine, so that the
+// adapter does not wait for more than the timeout, and does not call methods
+// of the type again once it timed out. The methods declared by interpreted
+// code are not part of the method set seen through reflection, they are
+// looked up with interpretedMethods.
+func (a *Adapter) callStringMethod(rv reflect.Value) (string, bool) {
+	v, ok := unrestricted(rv)
+	if !ok || !v.IsValid() {
+		return "", false
+	}
+
+	var describeV func() (string, bool)
+	methods := a.interpretedMethods()
+	switch {
+	case implementsStringMethod(v.Type()):
+		describeV = func() (string, bool) { return describe(v) }
+	case v.CanAddr() && implementsStringMethod(reflect.PointerTo(v.Type())):
+		v = v.Addr()
+		describeV = func() (string, bool) { return describe(v) }
+	case methods[v.Type()].IsValid():
+		fn := methods[v.Type()]
+		describeV = func() (string, bool) { return callInterpreted(fn, v) }
+	case v.CanAddr() && methods[reflect.PointerTo(v.Type())].IsValid():
+		fn := methods[reflect.PointerTo(v.Type())]
+		v = v.Addr()
+		describeV = func() (string, bool) { return callInterpreted(fn, v) }
+	default:
+		return "", false
+	}
+	if _, ok := a.blocking.Load(v.Type()); ok {
+		return "", false
+	}
+
+	type result struct {
+		s  string
+		ok bool
+	}
+	done := make(chan result, 1)
+	go func() {
+		s, ok := describeV()
+		done <- result{s, ok}
+	}()
+
+	timeout := a.opts.StringMethodTimeout
+	if timeout <= 0 {
+		timeout = 100 * time.Millisecond
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.s, r.ok
+	case <-timer.C:
+		a.blocking.Store(v.Type(), true)
+		return "", false
+	}
+}
+
+var (
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+func implementsStringMethod(t reflect.Type) bool {
+	return t.Implements(errorType) || t.Implements(stringerType)
+}
+
 func canBeNil(k reflect.Kind) bool {
 	return k == rChan || k == rFunc || k == rInterface || k == rMap || k == rPtr || k == rSlice
 }
 
 type variableScope interface {
-	Variables(a *Adapter) []*dap.Variable
+	// Variables returns the children of the scope selected by the page,
+	// printed in the format.
+	Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable
+	// Set assigns the value of a Go expression to the named variable, and
+	// returns its new value.
+	Set(a *Adapter, name, value string) (reflect.Value, error)
+}
+
+// assign sets v to the value of the Go expression, converted to the type of
+// v as in an assignment.
+func assign(e *evaluator, v reflect.Value, value string) error {
+	sv, ok := unrestricted(v)
+	if !ok || !sv.CanSet() {
+		return fmt.Errorf("cannot assign to %s value", v.Type())
+	}
+	nv, err := e.EvalAs(value, v.Type())
+	if err != nil {
+		return err
+	}
+	sv.Set(nv)
+	return nil
+}
+
+// unrestricted returns v, or an alias of v if v is an addressable value
+// obtained through unexported struct fields, so that it can be used and set:
+// the debugger has access to all of the program state. It reports false if v
+// is read-only and not addressable.
+func unrestricted(v reflect.Value) (reflect.Value, bool) {
+	switch {
+	case !v.IsValid() || v.CanInterface():
+		return v, true
+	case v.CanAddr():
+		return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem(), true //nolint:gosec // This is a valid use of unsafe.
+	default:
+		return v, false
+	}
 }
 
 type frameVars struct {
 	*interp.DebugFrameScope
 }
 
-func (f *frameVars) Variables(a *Adapter) []*dap.Variable {
+// page selects the children of a variable requested by the client.
+type page struct {
+	filter string // "indexed", "named", or empty for all children
+	start  int
+	count  int // 0 for all children after start
+}
+
+func newPage(args *dap.VariablesArguments) page {
+	return page{
+		filter: args.Filter.GetOr(""),
+		start:  args.Start.GetOr(0),
+		count:  args.Count.GetOr(0),
+	}
+}
+
+// window returns the bounds of the page within n children of the kind
+// selected by filter.
+func (p page) window(n int, filter string) (lo, hi int) {
+	if p.filter != "" && p.filter != filter {
+		return 0, 0
+	}
+	lo = min(max(p.start, 0), n)
+	if p.count <= 0 {
+		return lo, n
+	}
+	return lo, min(lo+p.count, n)
+}
+
+func (f *frameVars) Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable {
 	fv := f.DebugFrameScope.Variables()
+	lo, hi := p.window(len(fv), "named")
+	fv = fv[lo:hi]
 	vars := make([]*dap.Variable, 0, len(fv))
 
 	for _, v := range fv {
-		vars = append(vars, a.newVar(v.Name, v.Value))
+		vars = append(vars, a.newVar(routine, v.Name, v.Value, format))
 	}
 	return vars
 }
 
+func (f *frameVars) Set(a *Adapter, name, value string) (reflect.Value, error) {
+	fv := f.DebugFrameScope.Variables()
+	e := a.newEvaluator(nil)
+	e.vars = func(name string) (reflect.Value, bool) {
+		for _, v := range fv {
+			if v.Name == name {
+				return v.Value, true
+			}
+		}
+		return reflect.Value{}, false
+	}
+
+	v, ok := e.vars(name)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("undefined: %s", name)
+	}
+	return v, assign(e, v, value)
+}
+
 type elemVars struct {
 	reflect.Value
 }
 
-func (v *elemVars) Variables(a *Adapter) []*dap.Variable {
-	return []*dap.Variable{a.newVar("", v.Elem())}
+func (v *elemVars) Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable {
+	if lo, hi := p.window(1, "named"); lo == hi {
+		return nil
+	}
+	return []*dap.Variable{a.newVar(routine, "", v.Elem(), format)}
+}
+
+func (v *elemVars) Set(a *Adapter, name, value string) (reflect.Value, error) {
+	if v.IsNil() {
+		// the value changed since it was listed
+		return reflect.Value{}, fmt.Errorf("cannot assign to the element of nil %s", v.Type())
+	}
+	if v.Kind() == rPtr {
+		return v.Elem(), assign(a.newEvaluator(nil), v.Elem(), value)
+	}
+
+	// the dynamic value of an interface cannot be set, replace it with a
+	// value of the same type
+	elem := reflect.New(v.Elem().Type()).Elem()
+	if err := assign(a.newEvaluator(nil), elem, value); err != nil {
+		return reflect.Value{}, err
+	}
+	sv, ok := unrestricted(v.Value)
+	if !ok || !sv.CanSet() {
+		return reflect.Value{}, fmt.Errorf("cannot assign to %s value", v.Type())
+	}
+	sv.Set(elem)
+	return sv.Elem(), nil
 }
 
 type arrayVars struct {
 	reflect.Value
 }
 
-func (v *arrayVars) Variables(a *Adapter) []*dap.Variable {
-	vars := make([]*dap.Variable, v.Len())
-	for i := range vars {
-		vars[i] = a.newVar(strconv.Itoa(i), v.Index(i))
+func (v *arrayVars) Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable {
+	return a.elements(routine, v, 0, v.Len(), p, format)
+}
+
+func (v *arrayVars) element(a *Adapter, routine, i int, format valueFormat) *dap.Variable {
+	return a.newVar(routine, strconv.Itoa(i), v.Index(i), format)
+}
+
+func (v *arrayVars) Set(a *Adapter, name, value string) (reflect.Value, error) {
+	i, err := strconv.Atoi(name)
+	if err != nil || i < 0 || i >= v.Len() {
+		return reflect.Value{}, fmt.Errorf("invalid index %s", name)
 	}
-	return vars
+	return v.Index(i), assign(a.newEvaluator(nil), v.Index(i), value)
 }
 
 type structVars struct {
 	reflect.Value
 }
 
-func (v *structVars) Variables(a *Adapter) []*dap.Variable {
-	vars := make([]*dap.Variable, v.NumField())
+func (v *structVars) Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable {
+	lo, hi := p.window(v.NumField(), "named")
+	vars := make([]*dap.Variable, 0, hi-lo)
 	typ := v.Type()
-	for i := range vars {
+	for i := lo; i < hi; i++ {
 		f := typ.Field(i)
 		name := f.Name
 		if name == "" {
 			name = f.Type.Name()
 		}
-		vars[i] = a.newVar(name, v.Field(i))
+		vars = append(vars, a.newVar(routine, name, v.Field(i), format))
 	}
 	return vars
 }
 
+func (v *structVars) Set(a *Adapter, name, value string) (reflect.Value, error) {
+	typ := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Name == name || f.Name == "" && f.Type.Name() == name {
+			return v.Field(i), assign(a.newEvaluator(nil), v.Field(i), value)
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s has no field %s", typ, name)
+}
+
+// mapVars holds the entries of a map. The keys are listed once, so that the
+// entries keep their indices across paged requests, and entries are named
+// by their printed key, which is kept to find the entry to set.
 type mapVars struct {
 	reflect.Value
+	keys  []reflect.Value
+	names map[string]int // index of the entry of each name
+}
+
+func (v *mapVars) Len() int {
+	if v.keys == nil {
+		v.keys = sortedKeys(v.Value)
+	}
+	return len(v.keys)
+}
+
+func (v *mapVars) Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable {
+	return a.elements(routine, v, 0, v.Len(), p, format)
+}
+
+func (v *mapVars) element(a *Adapter, routine, i int, format valueFormat) *dap.Variable {
+	return a.newVar(routine, v.name(i), v.MapIndex(v.keys[i]), format)
+}
+
+// name returns the name of the i-th entry: its printed key, followed by the
+// index if another entry has the same name, as keys cut off at the maximum
+// length, pointers to equal values or NaN keys do.
+func (v *mapVars) name(i int) string {
+	if v.names == nil {
+		v.names = map[string]int{}
+	}
+	name := newValuePrinter(64).printString(v.keys[i])
+	if j, ok := v.names[name]; ok && j != i {
+		name = fmt.Sprintf("%s #%d", name, i)
+	}
+	v.names[name] = i
+	return name
+}
+
+func (v *mapVars) Set(a *Adapter, name, value string) (reflect.Value, error) {
+	i, ok := v.names[name]
+	for j := 0; !ok && j < v.Len(); j++ {
+		// the entry was not listed
+		if v.name(j) == name {
+			i, ok = j, true
+		}
+	}
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("no map entry %s", name)
+	}
+	k := v.keys[i]
+	if isNaN(k) {
+		return reflect.Value{}, errors.New("cannot assign to an entry with a NaN key")
+	}
+
+	// map elements are not addressable, assign a copy
+	elem := reflect.New(v.Type().Elem()).Elem()
+	if err := assign(a.newEvaluator(nil), elem, value); err != nil {
+		return reflect.Value{}, err
+	}
+	m, ok := unrestricted(v.Value)
+	if !ok {
+		return reflect.Value{}, errors.New("cannot assign to a map obtained from an unexported field")
+	}
+	if k, ok = unrestricted(k); !ok {
+		return reflect.Value{}, errors.New("cannot use a key obtained from an unexported field")
+	}
+	m.SetMapIndex(k, elem)
+	return m.MapIndex(k), nil
+}
+
+// isNaN returns whether the map key is a NaN, which matches no entry.
+func isNaN(k reflect.Value) bool {
+	if k.Kind() == rInterface && !k.IsNil() {
+		k = k.Elem()
+	}
+	switch k.Kind() {
+	case rFloat32, rFloat64:
+		return math.IsNaN(k.Float())
+	case rComplex64, rComplex128:
+		return cmplx.IsNaN(k.Complex())
+	}
+	return false
+}
+
+// sortedKeys returns the keys of the map in order, see compareKeys.
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return compareKeys(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// compareKeys compares map keys: numbers numerically, strings lexically,
+// false before true, pointers and channels by address, structs and arrays
+// element by element, and interfaces by the name of their dynamic type, then
+// by value.
+func compareKeys(a, b reflect.Value) int {
+	if a.Kind() != b.Kind() {
+		return cmp(int64(a.Kind()), int64(b.Kind()))
+	}
+
+	switch a.Kind() {
+	case rInt, rInt8, rInt16, rInt32, rInt64:
+		return cmp(a.Int(), b.Int())
+	case rUint, rUint8, rUint16, rUint32, rUint64, rUintptr:
+		return cmp(a.Uint(), b.Uint())
+	case rString:
+		return cmp(a.String(), b.String())
+	case rFloat32, rFloat64:
+		return cmp(a.Float(), b.Float())
+	case rComplex64, rComplex128:
+		if c := cmp(real(a.Complex()), real(b.Complex())); c != 0 {
+			return c
+		}
+		return cmp(imag(a.Complex()), imag(b.Complex()))
+	case rBool:
+		return cmp(btoi(a.Bool()), btoi(b.Bool()))
+	case rPtr, rUnsafePointer, rChan:
+		return cmp(uint64(a.Pointer()), uint64(b.Pointer()))
+	case rStruct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareKeys(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+	case rArray:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareKeys(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+	case rInterface:
+		switch {
+		case a.IsNil() || b.IsNil():
+			return cmp(btoi(!a.IsNil()), btoi(!b.IsNil()))
+		case a.Elem().Type() != b.Elem().Type():
+			return cmp(a.Elem().Type().String(), b.Elem().Type().String())
+		}
+		return compareKeys(a.Elem(), b.Elem())
+	}
+	return 0
+}
+
+func btoi(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// collection is a variable scope whose children are indexed.
+type collection interface {
+	variableScope
+	Len() int
+	element(a *Adapter, routine, i int, format valueFormat) *dap.Variable
+}
+
+// rangeVars holds the elements of a collection from start to end.
+type rangeVars struct {
+	collection
+	start, end int
+}
+
+func (v *rangeVars) Variables(a *Adapter, routine int, p page, format valueFormat) []*dap.Variable {
+	return a.elements(routine, v.collection, v.start, v.end, p, format)
 }
 
-func (v *mapVars) Variables(a *Adapter) []*dap.Variable {
-	keys := v.MapKeys()
-	vars := make([]*dap.Variable, len(keys))
-	vp := newValuePrinter(64)
-	for i, k := range keys {
-		vars[i] = a.newVar(vp.printString(k), v.MapIndex(k))
+// elements returns the elements of c from start to end selected by the page.
+// Unless the client requests a count, more than rangeSize elements are
+// grouped into range nodes, of rangeSize elements or of range nodes.
+func (a *Adapter) elements(routine int, c collection, start, end int, p page, format valueFormat) []*dap.Variable {
+	lo, hi := p.window(end-start, "indexed")
+	lo, hi = start+lo, start+hi
+
+	if p.count > 0 || hi-lo <= rangeSize {
+		vars := make([]*dap.Variable, 0, hi-lo)
+		for i := lo; i < hi; i++ {
+			vars = append(vars, c.element(a, routine, i, format))
+		}
+		return vars
+	}
+
+	size := rangeSize
+	for (hi-lo+size-1)/size > rangeSize {
+		size *= rangeSize
+	}
+	var vars []*dap.Variable
+	for i := lo; i < hi; i += size {
+		j := min(i+size, hi)
+		vars = append(vars, &dap.Variable{
+			Name:               fmt.Sprintf("[%d..%d]", i, j-1),
+			VariablesReference: a.vars.Add(routine, &rangeVars{c, i, j}, format),
+			IndexedVariables:   dap.Int(j - i),
+		})
 	}
 	return vars
 }
 
 // valuePrinter is for printing reflect.Value instances on a bounded buffer.
 type valuePrinter struct {
-	maxLength int
-	size      int // length of string before full
-	buffer    *bytes.Buffer
+	maxLength  int
+	size       int // length of string before full
+	buffer     *bytes.Buffer
+	hex        bool // see valueFormat
+	formatters *formatters
+	maxDepth   int // maximum nesting of maps, slices, arrays and structs
+	depth      int
+	visiting   map[reference]bool // references being printed, to detect cycles
+}
+
+// reference is a pointer, map or slice, identified by its address and type.
+type reference struct {
+	ptr uintptr
+	typ reflect.Type
 }
 
 func newValuePrinter(maximum int) *valuePrinter {
-	return &valuePrinter{maxLength: maximum, buffer: new(bytes.Buffer)}
+	return &valuePrinter{
+		maxLength:  maximum,
+		buffer:     new(bytes.Buffer),
+		formatters: defaultFormatters,
+		maxDepth:   defaultValueDepth,
+		visiting:   map[reference]bool{},
+	}
+}
+
+// newValuePrinter returns a valuePrinter using the formatters and the
+// maximum depth of the adapter.
+func (a *Adapter) newValuePrinter(maximum int) *valuePrinter {
+	p := newValuePrinter(maximum)
+	if a.formatters != nil {
+		p.formatters = a.formatters
+	}
+	if a.opts.MaxValueDepth > 0 {
+		p.maxDepth = a.opts.MaxValueDepth
+	}
+	return p
+}
+
+// enter reports whether the reference rv is not being printed already, and
+// marks it as being printed until leave is called. Otherwise it prints a
+// back-reference to it.
+func (p *valuePrinter) enter(rv reflect.Value) bool {
+	r := reference{rv.Pointer(), rv.Type()}
+	if p.visiting[r] {
+		fmt.Fprintf(p, "<cycle %s %#x>", r.typ, r.ptr)
+		return false
+	}
+	p.visiting[r] = true
+	return true
+}
+
+func (p *valuePrinter) leave(rv reflect.Value) {
+	delete(p.visiting, reference{rv.Pointer(), rv.Type()})
+}
+
+// nest reports whether the elements of rv can be printed within the maximum
+// depth, and nests them until unnest is called. Otherwise it prints an
+// ellipsis for them.
+func (p *valuePrinter) nest(rv reflect.Value) bool {
+	if p.depth >= p.maxDepth {
+		fmt.Fprintf(p, "%s{...}", rv.Type())
+		return false
+	}
+	p.depth++
+	return true
+}
+
+func (p *valuePrinter) unnest() {
+	p.depth--
 }
 
 // printString returns the printed string; the valuePrinter can be reused.
@@ -206,52 +734,121 @@ func (p *valuePrinter) printString(rv reflect.Value) string {
 }
 
 func (p *valuePrinter) print(rv reflect.Value) {
+	if s, ok := p.formatters.format(rv); ok {
+		fmt.Fprint(p, s)
+		return
+	}
+
 	switch rv.Kind() {
 	case rStruct:
-		// special case for time.Time
-		if rv.Type() == reflect.TypeOf(time.Time{}) {
-			t := rv.Interface().(time.Time)
-			fmt.Fprint(p, "time.Time "+t.String())
+		if !p.nest(rv) {
 			return
 		}
+		defer p.unnest()
 		fmt.Fprint(p, rv.Type().String())
+		fmt.Fprint(p, "{")
+		typ := rv.Type()
+		for i := 0; i < rv.NumField() && !p.full(); i++ {
+			if i > 0 {
+				fmt.Fprint(p, ",")
+			}
+			fmt.Fprint(p, typ.Field(i).Name)
+			fmt.Fprint(p, ":")
+			p.print(rv.Field(i))
+		}
+		fmt.Fprint(p, "}")
 	case rPtr:
 		if rv.IsNil() {
 			fmt.Fprintf(p, "%s nil", rv.Type().String())
 			return
 		}
+		if !p.enter(rv) {
+			return
+		}
+		defer p.leave(rv)
 		// try to show the value of the pointer element
 		fmt.Fprintf(p, "*")
 		p.print(rv.Elem())
 	case rChan, rFunc, rInterface:
 		fmt.Fprint(p, rv.Type().String())
 	case rInt, rInt8, rInt16, rInt32, rInt64:
+		if p.hex {
+			fmt.Fprintf(p, "%#x", rv.Int())
+			return
+		}
 		fmt.Fprint(p, strconv.FormatInt(rv.Int(), 10))
 	case rUint8, rUint16, rUint, rUint32, rUint64, rUintptr:
+		if p.hex {
+			fmt.Fprintf(p, "%#x", rv.Uint())
+			return
+		}
 		fmt.Fprintf(p, "%v", rv)
 	case rBool:
 		fmt.Fprint(p, strconv.FormatBool(rv.Bool()))
 	case rFloat32, rFloat64, rComplex128, rComplex64:
 		fmt.Fprintf(p, "%v", rv)
 	case rString:
+		if p.hex {
+			// a string literal of the bytes
+			fmt.Fprint(p, `"`)
+			for i, s := 0, rv.String(); i < len(s) && !p.full(); i++ {
+				fmt.Fprintf(p, `\x%02x`, s[i])
+			}
+			fmt.Fprint(p, `"`)
+			return
+		}
 		fmt.Fprintf(p, "%q", rv.String())
 	case rMap:
+		if !p.enter(rv) {
+			return
+		}
+		defer p.leave(rv)
+		if !p.nest(rv) {
+			return
+		}
+		defer p.unnest()
 		fmt.Fprint(p, rv.Type().String())
 		fmt.Fprint(p, "{")
-		for i, k := range rv.MapKeys() {
-			if i > 0 {
-				fmt.Fprint(p, ",")
+		if rv.Len() <= sortedMapLength {
+			for i, k := range sortedKeys(rv) {
+				if p.full() {
+					break
+				}
+				p.printEntry(i, k, rv.MapIndex(k))
+			}
+		} else {
+			it := rv.MapRange()
+			for i := 0; !p.full() && it.Next(); i++ {
+				p.printEntry(i, it.Key(), it.Value())
 			}
-			p.print(k)
-			fmt.Fprint(p, ":")
-			p.print(rv.MapIndex(k))
 		}
 		fmt.Fprint(p, "}")
 
 	case rSlice, rArray:
+		if rv.Kind() == rSlice && rv.Len() > 0 {
+			if !p.enter(rv) {
+				return
+			}
+			defer p.leave(rv)
+		}
+		if !p.nest(rv) {
+			return
+		}
+		defer p.unnest()
 		fmt.Fprint(p, rv.Type().String())
 		fmt.Fprint(p, "{")
-		for i := 0; i < rv.Len(); i++ {
+		if p.hex && rv.Type().Elem().Kind() == rUint8 {
+			// a dump of the bytes
+			for i := 0; i < rv.Len() && !p.full(); i++ {
+				if i > 0 {
+					fmt.Fprint(p, " ")
+				}
+				fmt.Fprintf(p, "%02x", rv.Index(i).Uint())
+			}
+			fmt.Fprint(p, "}")
+			return
+		}
+		for i := 0; i < rv.Len() && !p.full(); i++ {
 			if i > 0 {
 				fmt.Fprint(p, ",")
 			}
@@ -272,9 +869,26 @@ func (p *valuePrinter) String() string {
 	return s
 }
 
+// full reports whether the buffer overflowed, so that printing large values
+// can stop early.
+// printEntry prints the i-th entry of a map.
+func (p *valuePrinter) printEntry(i int, k, v reflect.Value) {
+	if i > 0 {
+		fmt.Fprint(p, ",")
+	}
+	p.print(k)
+	fmt.Fprint(p, ":")
+	p.print(v)
+}
+
+func (p *valuePrinter) full() bool {
+	return p.size > p.maxLength
+}
+
 func (p *valuePrinter) Write(b []byte) (n int, err error) {
 	rem := p.maxLength - p.size
 	if rem <= 0 {
+		p.size += len(b)
 		return 0, nil
 	}
 
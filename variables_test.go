@@ -2,10 +2,14 @@ package dbg
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
 )
 
 func Test_valuePrinter(t *testing.T) {
@@ -41,10 +45,17 @@ func Test_valuePrinter(t *testing.T) {
 		{"[]int", reflect.ValueOf([]int{}), "[]int{}"},
 		{"[]int{21,42}", reflect.ValueOf([]int{21, 42}), "[]int{21,42}"},
 		{"[2]bool{false, true}", reflect.ValueOf([2]bool{false, true}), "[2]bool{false,true}"},
-		{"func", reflect.ValueOf(a.newVar), "func(string, reflect.Value) *dap.Variable"},
-		{"struct", reflect.ValueOf(a), "*dbg.Adapter"},
+		{"func", reflect.ValueOf(a.newVar), "func(int, string, reflect.Value, dbg.valueFormat) *dap.Variable"},
+		{"struct", reflect.ValueOf(&setVarsPoint{X: 1, label: "a"}), `*dbg.setVarsPoint{X:1,label:"a"}`},
+		{"embedded struct", reflect.ValueOf(struct {
+			setVarsPoint
+			Y []int
+		}{setVarsPoint{X: 2}, nil}), `struct { dbg.setVarsPoint; Y []int }{setVarsPoint:dbg.setVarsPoint{X:2,label:""},Y:[]int{}}`},
+		{"empty struct", reflect.ValueOf(struct{}{}), "struct {}{}"},
 		{"map", reflect.ValueOf(map[bool]bool{}), "map[bool]bool{}"},
 		{"map[int]int{-1:2}", reflect.ValueOf(map[int]int{-1: 2}), "map[int]int{-1:2}"},
+		{"sorted map", reflect.ValueOf(map[int]bool{10: true, -1: false, 2: true}), "map[int]bool{-1:false,2:true,10:true}"},
+		{"sorted string keys", reflect.ValueOf(map[string]int{"b": 1, "a": 2, "B": 3}), `map[string]int{"B":3,"a":2,"b":1}`},
 		{"unsafe", reflect.ValueOf(u), fmt.Sprintf("reflect.Value %v", u)},
 		{"time", reflect.ValueOf(now), "time.Time 2024-06-06 01:02:03 +0000 UTC"},
 		{"*time", reflect.ValueOf(&now), "*time.Time 2024-06-06 01:02:03 +0000 UTC"},
@@ -52,7 +63,7 @@ func Test_valuePrinter(t *testing.T) {
 	}
 	for _, each := range cases {
 		t.Run(each.name, func(t *testing.T) {
-			dapVar := a.newVar(each.name, each.value)
+			dapVar := a.newVar(0, each.name, each.value, valueFormat{})
 			if got, want := dapVar.Value, each.out; got != want {
 				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
 			}
@@ -68,6 +79,7 @@ func Test_valuePrinter_print_smallMaxLength(t *testing.T) {
 	}{
 		{"string", reflect.ValueOf("some long text that is cut"), `"some lo...`},
 		{"[]int{12345678}", reflect.ValueOf([]int{12345678, 23456789}), "[]int{12..."},
+		{"struct", reflect.ValueOf(setVarsPoint{X: 12345678}), "dbg.setV..."},
 	}
 	for _, each := range cases {
 		t.Run(each.name, func(t *testing.T) {
@@ -80,6 +92,18 @@ func Test_valuePrinter_print_smallMaxLength(t *testing.T) {
 	}
 }
 
+func Test_valuePrinter_print_largeMap(t *testing.T) {
+	m := map[int]int{}
+	for i := 0; i < 10*sortedMapLength; i++ {
+		m[i] = i
+	}
+	vp := newValuePrinter(16)
+	vp.print(reflect.ValueOf(m))
+	if got := vp.String(); !strings.HasPrefix(got, "map[int]int{") || !strings.HasSuffix(got, "...") || len(got) > 20 {
+		t.Errorf("got [%[1]v:%[1]T]", got)
+	}
+}
+
 func Test_valuePrinter_printString(t *testing.T) {
 	vp := newValuePrinter(7)
 	rv := reflect.ValueOf("yaegi")
@@ -91,3 +115,299 @@ func Test_valuePrinter_printString(t *testing.T) {
 		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
 	}
 }
+
+type setVarsPoint struct {
+	X     int
+	label string
+}
+
+func Test_variableScope_Set(t *testing.T) {
+	a := &Adapter{vars: newVariables()}
+	p := &setVarsPoint{X: 1, label: "a"}
+	ints := []int{1, 2}
+	m := map[string]float64{"pi": 3}
+	var iface interface{} = 2
+
+	cases := []struct {
+		name  string
+		scope variableScope
+		field string
+		value string
+		out   string
+	}{
+		{"field", &structVars{reflect.ValueOf(p).Elem()}, "X", "1 + 1", "2"},
+		{"unexported field", &structVars{reflect.ValueOf(p).Elem()}, "label", `"b"`, `"b"`},
+		{"elem", &arrayVars{reflect.ValueOf(ints)}, "1", "-5", "-5"},
+		{"map entry", &mapVars{Value: reflect.ValueOf(m)}, `"pi"`, "3.14", "3.14"},
+		{"pointer", &elemVars{reflect.ValueOf(&ints[0])}, "", "7", "7"},
+		{"interface", &elemVars{reflect.ValueOf(&iface).Elem()}, "", "3", "3"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			rv, err := each.scope.Set(a, each.field, each.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := a.newVar(0, each.field, rv, valueFormat{}).Value, each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+	if p.X != 2 || p.label != "b" || ints[0] != 7 || ints[1] != -5 || m["pi"] != 3.14 || iface != 3 {
+		t.Errorf("values not set: %v %v %v %v", p, ints, m, iface)
+	}
+}
+
+func Test_variableScope_Set_errors(t *testing.T) {
+	a := &Adapter{vars: newVariables()}
+	ints := []int{1}
+	bytes := []uint8{44}
+	var u uint = 1
+	var nilIface interface{}
+	cases := []struct {
+		name  string
+		scope variableScope
+		field string
+		value string
+	}{
+		{"index", &arrayVars{reflect.ValueOf(ints)}, "1", "2"},
+		{"type", &arrayVars{reflect.ValueOf(ints)}, "0", `"2"`},
+		{"truncated", &arrayVars{reflect.ValueOf(ints)}, "0", "2.5"},
+		{"field", &structVars{reflect.ValueOf(setVarsPoint{})}, "X", "2"},
+		{"map entry", &mapVars{Value: reflect.ValueOf(map[int]int{})}, "1", "2"},
+		{"overflow", &arrayVars{reflect.ValueOf(bytes)}, "0", "300"},
+		{"negative", &elemVars{reflect.ValueOf(&u)}, "", "-1"},
+		{"nil interface", &elemVars{reflect.ValueOf(&nilIface).Elem()}, "", "1"},
+		{"NaN key", &mapVars{Value: reflect.ValueOf(map[float64]int{math.NaN(): 1})}, "NaN", "2"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			if _, err := each.scope.Set(a, each.field, each.value); err == nil {
+				t.Errorf("expected an error setting %s to %s", each.field, each.value)
+			}
+		})
+	}
+	if bytes[0] != 44 || u != 1 {
+		t.Errorf("values set: %v %v", bytes, u)
+	}
+}
+
+func Test_mapVars_Set_sameNames(t *testing.T) {
+	a := &Adapter{vars: newVariables()}
+	prefix := strings.Repeat("k", 100)
+	m := map[string]int{prefix + "a": 1, prefix + "b": 2}
+	v := &mapVars{Value: reflect.ValueOf(m)}
+
+	vars := v.Variables(a, 0, page{}, valueFormat{})
+	if len(vars) != 2 || vars[0].Name == vars[1].Name {
+		t.Fatalf("entries not named apart: %v", vars)
+	}
+	if _, err := v.Set(a, vars[1].Name, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if m[prefix+"a"] != 1 || m[prefix+"b"] != 3 {
+		t.Errorf("got [%[1]v:%[1]T]", m)
+	}
+}
+
+func Test_arrayVars_Variables_paged(t *testing.T) {
+	a := &Adapter{vars: newVariables()}
+	names := func(vars []*dap.Variable) []string {
+		var s []string
+		for _, v := range vars {
+			s = append(s, v.Name)
+		}
+		return s
+	}
+
+	cases := []struct {
+		name  string
+		len   int
+		page  page
+		names []string
+	}{
+		{"all", 3, page{}, []string{"0", "1", "2"}},
+		{"start", 3, page{start: 1}, []string{"1", "2"}},
+		{"count", 3, page{start: 1, count: 1}, []string{"1"}},
+		{"past end", 3, page{start: 5, count: 2}, nil},
+		{"named", 3, page{filter: "named"}, nil},
+		{"indexed", 3, page{filter: "indexed", count: 2}, []string{"0", "1"}},
+		{"ranges", 2500, page{}, []string{"[0..999]", "[1000..1999]", "[2000..2499]"}},
+		{"range of ranges", 2000000, page{}, []string{"[0..999999]", "[1000000..1999999]"}},
+		{"counted", 2500, page{start: 1998, count: 3}, []string{"1998", "1999", "2000"}},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			v := &arrayVars{reflect.ValueOf(make([]byte, each.len))}
+			if got, want := names(v.Variables(a, 0, each.page, valueFormat{})), each.names; !reflect.DeepEqual(got, want) {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+
+	v := &arrayVars{reflect.ValueOf(make([]byte, 2500))}
+	nodes := v.Variables(a, 0, page{}, valueFormat{})
+	scope, _, ok := a.vars.Get(nodes[2].VariablesReference)
+	if !ok {
+		t.Fatal("range node has no reference")
+	}
+	vars := scope.Variables(a, 0, page{start: 10, count: 2}, valueFormat{})
+	if got, want := names(vars), []string{"2010", "2011"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
+
+func Test_valuePrinter_hex(t *testing.T) {
+	cases := []struct {
+		name  string
+		value reflect.Value
+		out   string
+	}{
+		{"int", reflect.ValueOf(42), "0x2a"},
+		{"negative", reflect.ValueOf(int8(-42)), "-0x2a"},
+		{"uint", reflect.ValueOf(uint16(255)), "0xff"},
+		{"float", reflect.ValueOf(1.5), "1.5"},
+		{"string", reflect.ValueOf("hi!"), `"\x68\x69\x21"`},
+		{"[]byte", reflect.ValueOf([]byte("hi!")), "[]uint8{68 69 21}"},
+		{"[2]byte", reflect.ValueOf([2]byte{0, 10}), "[2]uint8{00 0a}"},
+		{"[]int", reflect.ValueOf([]int{10, 11}), "[]int{0xa,0xb}"},
+		{"map", reflect.ValueOf(map[string]int{"a": 16}), `map[string]int{"\x61":0x10}`},
+		{"long", reflect.ValueOf(make([]byte, 100)), "[]uint8{00 00 00 00 00 00 00 00 ..."},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			vp := newValuePrinter(32)
+			vp.hex = true
+			if got, want := vp.printString(each.value), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func Test_sortedKeys(t *testing.T) {
+	type key struct {
+		A string
+		B int
+	}
+	cases := []struct {
+		name string
+		m    interface{}
+		want string
+	}{
+		{"float", map[float64]int{2.5: 0, -1: 0, 10: 0}, "[-1 2.5 10]"},
+		{"bool", map[bool]int{true: 0, false: 0}, "[false true]"},
+		{"struct", map[key]int{{"b", 1}: 0, {"a", 2}: 0, {"a", 1}: 0}, "[{a 1} {a 2} {b 1}]"},
+		{"array", map[[2]uint]int{{2, 1}: 0, {1, 3}: 0, {1, 2}: 0}, "[[1 2] [1 3] [2 1]]"},
+		{"interface", map[interface{}]int{"b": 0, 2: 0, "a": 0, nil: 0, 1: 0}, "[<nil> 1 2 a b]"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			var keys []interface{}
+			for _, k := range sortedKeys(reflect.ValueOf(each.m)) {
+				keys = append(keys, k.Interface())
+			}
+			if got, want := fmt.Sprint(keys), each.want; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+type selfMap map[string]selfMap
+
+type selfSlice []*selfSlice
+
+type listNode struct {
+	value int
+	next  *listNode
+}
+
+func Test_valuePrinter_cycles(t *testing.T) {
+	m := selfMap{}
+	m["self"] = m
+	s := selfSlice{nil}
+	s[0] = &s
+	i := 42
+	nested := [][][]int{{{1}}}
+	ring := &listNode{value: 1}
+	ring.next = &listNode{value: 2, next: ring}
+
+	cases := []struct {
+		name  string
+		value reflect.Value
+		depth int
+		out   string
+	}{
+		{"map", reflect.ValueOf(m), 0, fmt.Sprintf(`dbg.selfMap{"self":<cycle dbg.selfMap %#x>}`, reflect.ValueOf(m).Pointer())},
+		{"slice", reflect.ValueOf(s), 0, fmt.Sprintf(`dbg.selfSlice{*<cycle dbg.selfSlice %#x>}`, reflect.ValueOf(s).Pointer())},
+		{"shared", reflect.ValueOf([]*int{&i, &i}), 0, "[]*int{*42,*42}"},
+		{"list", reflect.ValueOf(ring), 0, fmt.Sprintf(`*dbg.listNode{value:1,next:*dbg.listNode{value:2,next:<cycle *dbg.listNode %p>}}`, ring)},
+		{"depth", reflect.ValueOf(nested), 2, "[][][]int{[][]int{[]int{...}}}"},
+		{"default depth", reflect.ValueOf(nested), 0, "[][][]int{[][]int{[]int{1}}}"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			a := &Adapter{opts: Options{MaxValueDepth: each.depth}}
+			if got, want := a.newValuePrinter(128).printString(each.value), each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+type stringerValue int
+
+func (v stringerValue) String() string { return fmt.Sprintf("value %d", int(v)) }
+
+type stringerPointer struct{ n int }
+
+func (p *stringerPointer) String() string { return fmt.Sprintf("pointer %d", p.n) }
+
+type stringerPanic struct{}
+
+func (stringerPanic) Error() string { panic("broken") }
+
+type stringerBlocking chan int
+
+func (c stringerBlocking) String() string { return fmt.Sprint(<-c) }
+
+func Test_Adapter_stringMethod(t *testing.T) {
+	a := NewAdapter(nil, "", &Options{CallStringMethods: true, StringMethodTimeout: 10 * time.Millisecond})
+	now := time.Date(2024, 6, 6, 1, 2, 3, 0, time.UTC)
+	p := struct{ P stringerPointer }{stringerPointer{3}}
+	block := make(stringerBlocking)
+	defer close(block)
+
+	cases := []struct {
+		name   string
+		value  reflect.Value
+		format valueFormat
+		out    string
+	}{
+		{"stringer", reflect.ValueOf(stringerValue(1)), valueFormat{}, "value 1"},
+		{"hex", reflect.ValueOf(stringerValue(10)), valueFormat{hex: true}, "0xa"},
+		{"pointer receiver", reflect.ValueOf(&p).Elem().Field(0), valueFormat{}, "pointer 3"},
+		{"not addressable", reflect.ValueOf(stringerPointer{4}), valueFormat{}, "dbg.stringerPointer{n:4}"},
+		{"error", reflect.ValueOf(fmt.Errorf("failed")), valueFormat{}, "failed"},
+		{"panic", reflect.ValueOf(stringerPanic{}), valueFormat{}, "dbg.stringerPanic{}"},
+		{"timeout", reflect.ValueOf(block), valueFormat{}, "dbg.stringerBlocking"},
+		{"formatter", reflect.ValueOf(now), valueFormat{}, "time.Time 2024-06-06 01:02:03 +0000 UTC"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			if got, want := a.newVar(0, each.name, each.value, each.format).Value, each.out; got != want {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+
+	if _, ok := a.blocking.Load(reflect.TypeOf(block)); !ok {
+		t.Error("blocking type not recorded")
+	}
+
+	a.opts.CallStringMethods = false
+	if got, want := a.newVar(0, "", reflect.ValueOf(stringerValue(1)), valueFormat{}).Value, "1"; got != want {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package dbg
+
+import (
+	"context"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+// watch polls the sources of the program every Watch interval, and restarts
+// it when they change, until the adapter terminates. The files are walked
+// without mu, which is only held to list them and to restart. The caller
+// must hold mu.
+func (a *Adapter) watch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.unwatch = cancel
+
+	go func() {
+		ticker := time.NewTicker(a.opts.Watch)
+		defer ticker.Stop()
+
+		files := a.watchedFiles()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			changed, ok := changedFile(files, a.watchedFiles())
+			if ok {
+				a.mu.Lock()
+				if ctx.Err() == nil {
+					a.console("%s changed, restarting\n", changed)
+					a.reload()
+				}
+				a.mu.Unlock()
+			}
+			files = a.watchedFiles()
+		}
+	}()
+}
+
+// reload restarts the program after its sources changed. A client that
+// requests a restart drops the routines of the previous program by itself;
+// here it is told that they continued and exited, and that the state of the
+// remaining ones must be fetched again. The caller must hold mu.
+func (a *Adapter) reload() {
+	var stopped []int
+	var previous []int
+	if a.debugger != nil {
+		for _, r := range a.debugger.GoRoutines() {
+			previous = append(previous, r.ID())
+			if _, ok := a.events.Get(r.ID()); ok {
+				stopped = append(stopped, r.ID())
+			}
+		}
+	}
+
+	if ok, msg := a.restart(nil); !ok {
+		a.console("%s\n", msg)
+		return
+	}
+
+	if len(stopped) > 0 {
+		err := a.session.Event("continued", &dap.ContinuedEventBody{
+			ThreadId:            stopped[0],
+			AllThreadsContinued: dap.Bool(true),
+		})
+		if a.opts.Errors != nil && err != nil {
+			a.opts.Errors <- err
+		}
+	}
+	current := map[int]bool{}
+	for _, r := range a.debugger.GoRoutines() {
+		current[r.ID()] = true
+	}
+	for _, id := range previous {
+		if !current[id] {
+			err := a.session.Event("thread", &dap.ThreadEventBody{Reason: "exited", ThreadId: id})
+			if a.opts.Errors != nil && err != nil {
+				a.opts.Errors <- err
+			}
+		}
+	}
+	a.invalidate(0, "all")
+}
+
+// fileStat is the state of a watched file. A file is changed once either
+// differs, without reading it.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchedFiles returns the state of the sources of the program: SrcPath for
+// a program compiled from a string, the Go files of the compiled path
+// otherwise, and the files of the interpreted packages. The paths are listed
+// under mu, the files are walked and stated without it.
+func (a *Adapter) watchedFiles() map[string]fileStat {
+	a.mu.Lock()
+	var dir string
+	var paths []string
+	if a.src != "" {
+		if a.opts.SrcPath != "" {
+			paths = append(paths, a.opts.SrcPath)
+		}
+	} else {
+		dir = a.arg
+	}
+	if a.interp != nil {
+		// files that do not exist, such as a program compiled from a string,
+		// are skipped
+		a.interp.FileSet().Iterate(func(f *token.File) bool {
+			paths = append(paths, f.Name())
+			return true
+		})
+	}
+	a.mu.Unlock()
+
+	files := map[string]fileStat{}
+	add := func(path string) {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			files[path] = fileStat{info.ModTime(), info.Size()}
+		}
+	}
+	if dir != "" {
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil:
+				return nil
+			case d.IsDir() && path != dir && strings.HasPrefix(d.Name(), "."):
+				return filepath.SkipDir
+			case !d.IsDir() && filepath.Ext(path) == ".go":
+				add(path)
+			}
+			return nil
+		})
+	}
+	for _, path := range paths {
+		add(path)
+	}
+	return files
+}
+
+// changedFile returns a file that was added, removed or modified.
+func changedFile(before, after map[string]fileStat) (string, bool) {
+	for path, st := range after {
+		if u, ok := before[path]; !ok || u.size != st.size || !u.modTime.Equal(st.modTime) {
+			return path, true
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			return path, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,76 @@
+package dbg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/traefik-contrib/yaegi-debug-adapter/pkg/dap"
+)
+
+func Test_changedFile(t *testing.T) {
+	t0 := time.Date(2024, 6, 6, 1, 2, 3, 0, time.UTC)
+	t1 := t0.Add(time.Second)
+
+	a0 := fileStat{t0, 10}
+	a1 := fileStat{t1, 10}
+	a2 := fileStat{t0, 12}
+
+	cases := []struct {
+		name   string
+		before map[string]fileStat
+		after  map[string]fileStat
+		out    string
+	}{
+		{"unchanged", map[string]fileStat{"a.go": a0}, map[string]fileStat{"a.go": a0}, ""},
+		{"modified", map[string]fileStat{"a.go": a0}, map[string]fileStat{"a.go": a1}, "a.go"},
+		{"resized", map[string]fileStat{"a.go": a0}, map[string]fileStat{"a.go": a2}, "a.go"},
+		{"added", map[string]fileStat{"a.go": a0}, map[string]fileStat{"a.go": a0, "b.go": a0}, "b.go"},
+		{"removed", map[string]fileStat{"a.go": a0, "b.go": a0}, map[string]fileStat{"a.go": a0}, "b.go"},
+	}
+	for _, each := range cases {
+		t.Run(each.name, func(t *testing.T) {
+			got, ok := changedFile(each.before, each.after)
+			if want := each.out; got != want || ok != (want != "") {
+				t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", got, want)
+			}
+		})
+	}
+}
+
+func TestAdapter_watchRestart(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reload := func() (string, error) {
+		b, err := os.ReadFile(path)
+		return string(b), err
+	}
+
+	a := NewEvalAdapter(src, &Options{SrcPath: path, Watch: 10 * time.Millisecond, Reload: reload})
+	c := newTestClient(t, a)
+	a.ccaps.SupportsInvalidatedEvent = dap.Bool(true)
+	c.launch(&dap.SourceBreakpoint{Line: 5})
+	first := c.stopped()
+
+	if err := os.WriteFile(path, []byte(strings.Replace(src, "1", "22", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client drops the stopped routine of the previous program
+	cont := c.event("continued").Body.(*dap.ContinuedEventBody)
+	if cont.ThreadId != first.ThreadId.Get() || !cont.AllThreadsContinued.True() {
+		t.Errorf("got [%[1]v:%[1]T] want [%[2]v:%[2]T]", cont, first.ThreadId.Get())
+	}
+	c.event("invalidated")
+
+	// the new program stops at the restored breakpoint
+	stop := c.stopped()
+	c.cont(stop.ThreadId.Get())
+	c.event("terminated")
+	c.disconnect()
+}